@@ -1,18 +1,26 @@
 package lily
 
 import (
+	"bytes"
 	"fmt"
-	"log"
+	"net/http"
+	"os"
 	"runtime"
 	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
 )
 
 func ErrPanic(err error, msg ...string) {
 	if err != nil {
 		if len(msg) > 0 {
-			log.Panicln(err, msg[0])
+			Log().Error(msg[0], "error", err)
+			panic(fmt.Sprintln(err, msg[0]))
 		} else {
-			log.Panicln(err)
+			Log().Error("panic", "error", err)
+			panic(fmt.Sprintln(err))
 		}
 	}
 }
@@ -25,19 +33,84 @@ func ErrPanicSilent(err error) {
 
 func ErrPanicWS(err error, msg ...string) {
 	if err != nil {
+		stack := string(debug.Stack())
+		var value string
 		if len(msg) > 0 {
-			log.Panicln(err, msg[0], "\r\n", string(debug.Stack()))
+			value = fmt.Sprintln(err, msg[0], "\r\n", stack)
 		} else {
-			log.Panicln(err, "\r\n", string(debug.Stack()))
+			value = fmt.Sprintln(err, "\r\n", stack)
 		}
+		// registered recoverer logs and reports the panic itself, with details of the request
+		if deferPanic(value, &PendingPanic{Err: err, Msg: strings.Join(msg, " "), Stack: stack}) {
+			panic(value)
+		}
+		ReportPanic(err, []byte(stack), nil)
+		if len(msg) > 0 {
+			Log().Error(msg[0], "error", err, "stack", stack)
+		} else {
+			Log().Error("panic", "error", err, "stack", stack)
+		}
+		panic(value)
 	}
 }
 
 func ErrFatal(err error, msg ...string) {
 	if err != nil {
 		_, file, line, _ := runtime.Caller(1)
-		log.Fatalf("fatal (%s:%d):\n\t%s\n\t%s\n", file, line, msg, err.Error())
+		Log().Error("fatal", "file", file, "line", line, "details", strings.Join(msg, " "), "error", err)
+		os.Exit(1)
+	}
+}
+
+// CallerErr is an error with location of the code, where it was wrapped
+type CallerErr struct {
+	Err  error
+	Msg  string
+	File string
+	Line int
+}
+
+func (e *CallerErr) Error() string {
+	if e.Msg != "" {
+		return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Msg, e.Err.Error())
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err.Error())
+}
+
+func (e *CallerErr) Unwrap() error {
+	return e.Err
+}
+
+// ErrWrap is the error-returning alternative of ErrPanic: nil stays nil,
+// other errors get file and line of the caller, like ErrFatal prints them
+func ErrWrap(err error, msg ...string) error {
+	return errWrap(2, err, msg...)
+}
+
+// ErrWrapCaller is ErrWrap for error-returning library functions,
+// it takes location of the code, which called the function calling ErrWrapCaller
+func ErrWrapCaller(err error, msg ...string) error {
+	return errWrap(3, err, msg...)
+}
+
+func errWrap(skip int, err error, msg ...string) error {
+	if err == nil {
+		return nil
+	}
+	_, file, line, _ := runtime.Caller(skip)
+	e := &CallerErr{Err: err, File: file, Line: line}
+	if len(msg) > 0 {
+		e.Msg = msg[0]
 	}
+	return e
+}
+
+// Must returns v or panics with err wrapped by the caller location
+func Must[T any](v T, err error) T {
+	if err != nil {
+		panic(errWrap(2, err))
+	}
+	return v
 }
 
 type VErr struct {
@@ -46,12 +119,61 @@ type VErr struct {
 	Detail     string
 	DetailPars map[string]string
 	Extras     []string // for http-responding
+	Status     int      // http status code, taken from registry by Code if zero
 }
 
 func (e *VErr) Error() string {
 	return fmt.Sprintf("%s, %s", e.Code, e.Detail)
 }
 
+func (e *VErr) Unwrap() error {
+	return e.Err
+}
+
+// Is reports VErr-s with the same code as equal, so VErr-s may be used as sentinel errors
+func (e *VErr) Is(target error) bool {
+	t, ok := target.(*VErr)
+	return ok && t != nil && t.Code == e.Code
+}
+
+func (e *VErr) StatusCode() int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	return VErrCodeStatus(e.Code)
+}
+
+var (
+	vErrStatusesMu sync.RWMutex
+	vErrStatuses   = map[string]int{
+		"bad_json":          http.StatusBadRequest,
+		"service_na":        http.StatusBadRequest,
+		"unauthorized":      http.StatusUnauthorized,
+		"permission_denied": http.StatusForbidden,
+		"not_found":         http.StatusNotFound,
+		"internal_error":    http.StatusInternalServerError,
+		"validation_failed": http.StatusBadRequest,
+	}
+)
+
+// RegisterVErrCode sets http status code for VErr-s with the code
+func RegisterVErrCode(code string, status int) {
+	vErrStatusesMu.Lock()
+	vErrStatuses[code] = status
+	vErrStatusesMu.Unlock()
+}
+
+// VErrCodeStatus returns registered status code, 400 for unknown codes
+func VErrCodeStatus(code string) int {
+	vErrStatusesMu.RLock()
+	status, ok := vErrStatuses[code]
+	vErrStatusesMu.RUnlock()
+	if !ok {
+		return http.StatusBadRequest
+	}
+	return status
+}
+
 func NewVErr(err error, code, det string, detP map[string]string) *VErr {
 	return &VErr{
 		Err:        err,
@@ -60,3 +182,100 @@ func NewVErr(err error, code, det string, detP map[string]string) *VErr {
 		DetailPars: detP,
 	}
 }
+
+// VErrs collects validation errors by field path, like "items.0.name"
+type VErrs map[string]*VErr
+
+func (e VErrs) Add(field, code, detail string, detailPars map[string]string) {
+	e[field] = NewVErr(nil, code, detail, detailPars)
+}
+
+// AddVErr ignores nil err
+func (e VErrs) AddVErr(field string, err *VErr) {
+	if err != nil {
+		e[field] = err
+	}
+}
+
+// Merge adds errors of nested object, their fields are prefixed by "prefix."
+func (e VErrs) Merge(prefix string, other VErrs) {
+	for field, err := range other {
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+		e[field] = err
+	}
+}
+
+func (e VErrs) Has(field string) bool {
+	_, ok := e[field]
+	return ok
+}
+
+func (e VErrs) Empty() bool {
+	return len(e) == 0
+}
+
+// Err returns nil for empty collection, so result can be compared with nil safely
+func (e VErrs) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e VErrs) Fields() []string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func (e VErrs) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, field := range e.Fields() {
+		if e[field] != nil {
+			parts = append(parts, field+": "+e[field].Error())
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// TranslateFunc has the signature of go-i18n TranslateFunc, so it can be converted directly
+type TranslateFunc func(translationID string, args ...interface{}) string
+
+// Localize translates Detail and fills its {{.Par}} placeholders with DetailPars.
+// Untranslated detail is filled here, t may be nil. Translated one is filled by t
+// and is not parsed again, because it may contain pars values.
+func (e *VErr) Localize(t TranslateFunc) string {
+	var pars map[string]interface{}
+	if len(e.DetailPars) > 0 {
+		pars = make(map[string]interface{}, len(e.DetailPars))
+		for k, v := range e.DetailPars {
+			pars[k] = v
+		}
+	}
+
+	detail := e.Detail
+	if t != nil {
+		if pars != nil {
+			detail = t(e.Detail, pars)
+		} else {
+			detail = t(e.Detail)
+		}
+	}
+
+	if pars != nil && detail == e.Detail && strings.Contains(detail, "{{") {
+		tpl, err := template.New("").Option("missingkey=zero").Parse(detail)
+		if err == nil {
+			buf := &bytes.Buffer{}
+			if tpl.Execute(buf, pars) == nil {
+				detail = buf.String()
+			}
+		}
+	}
+
+	return detail
+}
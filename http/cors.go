@@ -0,0 +1,210 @@
+package http
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CORSOptions configures MwCORS
+type CORSOptions struct {
+	// AllowedOrigins are exact origins like "https://example.com",
+	// wildcard subdomains like "https://*.example.com" or "*" for any origin
+	AllowedOrigins []string
+	// AllowedOriginPatterns must match whole Origin header, they need no ^ and $
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods is GET, HEAD, POST when empty
+	AllowedMethods []string
+	// AllowedHeaders is Accept, Accept-Language, Content-Language, Content-Type when empty, "*" allows any
+	AllowedHeaders []string
+	ExposedHeaders []string
+	// AllowCredentials is ignored when any origin is allowed by "*"
+	AllowCredentials bool
+	// MaxAge of preflight result in seconds, not sent when zero
+	MaxAge int
+}
+
+type corsWildcard struct {
+	prefix string
+	suffix string
+}
+
+type cors struct {
+	allowAll         bool
+	origins          map[string]bool
+	wildcards        []corsWildcard
+	patterns         []*regexp.Regexp
+	methods          map[string]bool
+	allowedMethods   string
+	anyHeader        bool
+	headers          map[string]bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORS(opts *CORSOptions) *cors {
+	if opts == nil {
+		opts = &CORSOptions{}
+	}
+	c := &cors{
+		origins:          map[string]bool{},
+		methods:          map[string]bool{},
+		headers:          map[string]bool{},
+		allowCredentials: opts.AllowCredentials,
+	}
+
+	// anchored, so pattern can not match part of an origin like "https://example.com.evil.io"
+	for _, p := range opts.AllowedOriginPatterns {
+		c.patterns = append(c.patterns, regexp.MustCompile(`^(?:`+p.String()+`)$`))
+	}
+
+	for _, o := range opts.AllowedOrigins {
+		o = strings.ToLower(o)
+		if o == "*" {
+			c.allowAll = true
+		} else if i := strings.IndexByte(o, '*'); i >= 0 {
+			c.wildcards = append(c.wildcards, corsWildcard{prefix: o[:i], suffix: o[i+1:]})
+		} else {
+			c.origins[o] = true
+		}
+	}
+
+	methods := make([]string, 0, len(opts.AllowedMethods))
+	for _, m := range opts.AllowedMethods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD", "POST"}
+	}
+	for _, m := range methods {
+		c.methods[m] = true
+	}
+	c.allowedMethods = strings.Join(methods, ", ")
+
+	headers := opts.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+	}
+	for _, h := range headers {
+		if h == "*" {
+			c.anyHeader = true
+		}
+		c.headers[strings.ToLower(h)] = true
+	}
+
+	exposed := make([]string, 0, len(opts.ExposedHeaders))
+	for _, h := range opts.ExposedHeaders {
+		exposed = append(exposed, http.CanonicalHeaderKey(h))
+	}
+	c.exposedHeaders = strings.Join(exposed, ", ")
+
+	if opts.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(opts.MaxAge)
+	}
+
+	return c
+}
+
+func (c *cors) originAllowed(origin string) bool {
+	if c.allowAll {
+		return true
+	}
+	lOrigin := strings.ToLower(origin)
+	if c.origins[lOrigin] {
+		return true
+	}
+	for _, w := range c.wildcards {
+		if len(lOrigin) > len(w.prefix)+len(w.suffix) &&
+			strings.HasPrefix(lOrigin, w.prefix) &&
+			strings.HasSuffix(lOrigin, w.suffix) {
+			return true
+		}
+	}
+	for _, p := range c.patterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cors) headersAllowed(requested string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" && !c.headers[h] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *cors) setAllowOrigin(w http.ResponseWriter, origin string) {
+	if c.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if c.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+
+	// disallowed preflight is answered without cors headers, so browser rejects the actual request
+	if c.originAllowed(origin) &&
+		c.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] &&
+		c.headersAllowed(reqHeaders) {
+		c.setAllowOrigin(w, origin)
+		w.Header().Set("Access-Control-Allow-Methods", c.allowedMethods)
+		if reqHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if c.maxAge != "" {
+			w.Header().Set("Access-Control-Max-Age", c.maxAge)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MwCORS handles preflight requests itself and adds cors headers to actual requests from allowed origins,
+// nil opts allow no origins
+func MwCORS(h http.Handler, opts *CORSOptions) http.Handler {
+	c := newCORS(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// responses differ by Origin, so Vary is sent for requests without it too
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			c.preflight(w, r)
+			return
+		}
+
+		if c.originAllowed(origin) {
+			c.setAllowOrigin(w, origin)
+			if c.exposedHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", c.exposedHeaders)
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -2,20 +2,23 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/rendau/lily"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	netUrl "net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"runtime/debug"
 	"strings"
+	"time"
 )
 
 func MwCORSAllowAll(h http.Handler, maxAge string) http.Handler {
@@ -40,25 +43,72 @@ func MwCORSAllowAll(h http.Handler, maxAge string) http.Handler {
 	})
 }
 
+// RecoveryRedactedHeaders are not logged and reported by MwRecovery as is
+var RecoveryRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+func redactedHeaders(header http.Header) map[string]string {
+	headers := map[string]string{}
+	for name, values := range header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	for _, name := range RecoveryRedactedHeaders {
+		name = strings.ToLower(name)
+		if _, ok := headers[name]; ok {
+			headers[name] = "[REDACTED]"
+		}
+	}
+	return headers
+}
+
+func init() {
+	lily.RegisterRecoverer(runtime.FuncForPC(reflect.ValueOf(MwRecovery).Pointer()).Name())
+}
+
+// MwRecovery responds with internal_error and request id on panic, if the handler did not start responding yet.
+// Panics of lily.ErrPanicWS are logged and reported here once, with details of the request.
 func MwRecovery(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := newResponseWriter(w)
 		defer func() {
 			if err := recover(); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				headersStr := ""
-				for name, headers := range r.Header {
-					name = strings.ToLower(name)
-					for _, hdr := range headers {
-						headersStr += "   " + name + ": " + hdr + "\n"
-					}
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				reqID := requestID(r)
+
+				var cause interface{} = err
+				stack := debug.Stack()
+				details := ""
+				if p, ok := lily.TakePendingPanic(err); ok {
+					cause, stack, details = p.Err, []byte(p.Stack), p.Msg
+				}
+
+				headers := redactedHeaders(r.Header)
+				args := []interface{}{
+					"request_id", reqID,
+					"method", r.Method,
+					"url", r.URL.String(),
+					"error", fmt.Sprint(cause),
+				}
+				if details != "" {
+					args = append(args, "details", details)
+				}
+				lily.Log().Error("http handler panic", append(args, "headers", headers, "stack", string(stack))...)
+				lily.ReportPanic(cause, stack, &lily.ReportRequest{
+					Method:   r.Method,
+					URL:      r.URL.String(),
+					RemoteIP: RetrieveRemoteIP(r),
+					Headers:  headers,
+				})
+
+				if !rw.wroteHeader {
+					rw.Header().Set(RequestIDHeader, reqID)
+					RespondError(rw, http.StatusInternalServerError, "internal_error", "Internal server error", "request_id", reqID)
 				}
-				log.Printf(
-					"\nFail to:\n   %v %v\nError:\n   %v\nHTTP Headers:\n%vStack:\n%v",
-					r.Method, r.URL, err, headersStr, string(debug.Stack()),
-				)
 			}
 		}()
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(rw, r)
 	})
 }
 
@@ -82,31 +132,51 @@ func RespondStr(w http.ResponseWriter, code int, body string) {
 	fmt.Fprint(w, body)
 }
 
+// RespondStrErr is RespondStr, which returns errors instead of panic
+func RespondStrErr(w http.ResponseWriter, code int, body string) error {
+	if len(body) == 0 {
+		return lily.ErrWrapCaller(errors.New("body must be not empty"))
+	}
+	w.WriteHeader(code)
+	_, err := fmt.Fprint(w, body)
+	return lily.ErrWrapCaller(err)
+}
+
 func RespondJSONObj(w http.ResponseWriter, code int, obj interface{}) {
 	SetContentTypeJSON(w)
 	w.WriteHeader(code)
 	lily.ErrPanic(json.NewEncoder(w).Encode(obj))
 }
 
+// RespondJSONObjErr is RespondJSONObj, which returns errors instead of panic.
+// Object is encoded before writing the header, so status is not sent for unencodable object.
+func RespondJSONObjErr(w http.ResponseWriter, code int, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return lily.ErrWrapCaller(err)
+	}
+	SetContentTypeJSON(w)
+	w.WriteHeader(code)
+	_, err = w.Write(append(data, '\n'))
+	return lily.ErrWrapCaller(err)
+}
+
 func RespondJSONParseError(w http.ResponseWriter) {
 	Respond400(w, "bad_json", "Fail to parse JSON")
 }
 
-func SendRequest(client *http.Client, method, url string, urlParams map[string]string,
-	data []byte, headers ...string) (*http.Response, error) {
+func newRequest(ctx context.Context, method, url string, urlParams map[string]string,
+	data []byte, headers ...string) (*http.Request, error) {
 	var err error
 	var req *http.Request
 
-	if client == nil {
-		lily.ErrPanic(errors.New("client is nil"))
-	}
-
 	if data != nil {
-		req, err = http.NewRequest(method, url, bytes.NewBuffer(data))
-		lily.ErrPanic(err)
+		req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
 	} else {
-		req, err = http.NewRequest(method, url, nil)
-		lily.ErrPanic(err)
+		req, err = http.NewRequestWithContext(ctx, method, url, nil)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	if urlParams != nil {
@@ -121,20 +191,80 @@ func SendRequest(client *http.Client, method, url string, urlParams map[string]s
 		req.Header.Set(headers[i], headers[i+1])
 	}
 
+	return req, nil
+}
+
+// SendRequest panics on nil client or bad request, SendRequestCtx returns errors instead
+func SendRequest(client *http.Client, method, url string, urlParams map[string]string,
+	data []byte, headers ...string) (*http.Response, error) {
+	if client == nil {
+		lily.ErrPanic(errors.New("client is nil"))
+	}
+
+	req, err := newRequest(context.Background(), method, url, urlParams, data, headers...)
+	lily.ErrPanic(err)
+
 	return client.Do(req)
 }
 
+// SendRequestCtx is SendRequest, which is cancelled with ctx and retried by retry policy, nil policy means no retries
+func SendRequestCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, method, url string, urlParams map[string]string,
+	data []byte, headers ...string) (*http.Response, error) {
+	if client == nil {
+		return nil, errors.New("client is nil")
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest(ctx, method, url, urlParams, data, headers...)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if ctx.Err() != nil || !retry.shouldRetry(attempt, method, resp, err) {
+			return resp, err
+		}
+
+		delay := retry.delay(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
 func SendRequestReceiveBytes(client *http.Client, errSCode bool, method, url string, urlParams map[string]string,
 	data []byte, headers ...string) (int, []byte, error) {
-	var res []byte
-
 	resp, err := SendRequest(client, method, url, urlParams, data, headers...)
 	if err != nil {
 		return 0, nil, err
 	}
+
+	return receiveBytes(resp, errSCode)
+}
+
+func SendRequestReceiveBytesCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, errSCode bool,
+	method, url string, urlParams map[string]string, data []byte, headers ...string) (int, []byte, error) {
+	resp, err := SendRequestCtx(ctx, client, retry, method, url, urlParams, data, headers...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return receiveBytes(resp, errSCode)
+}
+
+func receiveBytes(resp *http.Response, errSCode bool) (int, []byte, error) {
 	defer resp.Body.Close()
 
-	res, err = ioutil.ReadAll(resp.Body)
+	res, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -156,10 +286,30 @@ func SendRequestReceiveString(client *http.Client, errSCode bool, method, url st
 	return sCode, string(resBytes), err
 }
 
+func SendRequestReceiveStringCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, errSCode bool,
+	method, url string, urlParams map[string]string, data []byte, headers ...string) (int, string, error) {
+	sCode, resBytes, err := SendRequestReceiveBytesCtx(ctx, client, retry, errSCode, method, url, urlParams, data, headers...)
+
+	return sCode, string(resBytes), err
+}
+
 func SendRequestReceiveJSONObj(client *http.Client, errSCode bool, method, url string, urlParams map[string]string,
 	data []byte, rObj interface{}, headers ...string) (int, []byte, error) {
 	sCode, rBytes, err := SendRequestReceiveBytes(
 		client, errSCode, method, url, urlParams, data, headers...)
+
+	return decodeJSONObj(sCode, rBytes, err, rObj)
+}
+
+func SendRequestReceiveJSONObjCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, errSCode bool,
+	method, url string, urlParams map[string]string, data []byte, rObj interface{}, headers ...string) (int, []byte, error) {
+	sCode, rBytes, err := SendRequestReceiveBytesCtx(
+		ctx, client, retry, errSCode, method, url, urlParams, data, headers...)
+
+	return decodeJSONObj(sCode, rBytes, err, rObj)
+}
+
+func decodeJSONObj(sCode int, rBytes []byte, err error, rObj interface{}) (int, []byte, error) {
 	if err != nil || !StatusCodeIsOk(sCode) {
 		return sCode, rBytes, err
 	}
@@ -182,6 +332,16 @@ func SendJSONObjRequest(client *http.Client, method, url string, urlParams map[s
 	return SendRequest(client, method, url, urlParams, sBytes, headers...)
 }
 
+func SendJSONObjRequestCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, method, url string, urlParams map[string]string,
+	sObj interface{}, headers ...string) (*http.Response, error) {
+	sBytes, err := json.Marshal(sObj)
+	if err != nil {
+		return nil, err
+	}
+
+	return SendRequestCtx(ctx, client, retry, method, url, urlParams, sBytes, headers...)
+}
+
 func SendJSONObjRequestReceiveBytes(client *http.Client, errSCode bool, method, url string, urlParams map[string]string,
 	sObj interface{}, headers ...string) (int, []byte, error) {
 	sBytes, err := json.Marshal(sObj)
@@ -192,6 +352,16 @@ func SendJSONObjRequestReceiveBytes(client *http.Client, errSCode bool, method,
 	return SendRequestReceiveBytes(client, errSCode, method, url, urlParams, sBytes, headers...)
 }
 
+func SendJSONObjRequestReceiveBytesCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, errSCode bool,
+	method, url string, urlParams map[string]string, sObj interface{}, headers ...string) (int, []byte, error) {
+	sBytes, err := json.Marshal(sObj)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return SendRequestReceiveBytesCtx(ctx, client, retry, errSCode, method, url, urlParams, sBytes, headers...)
+}
+
 func SendJSONObjRequestReceiveString(client *http.Client, errSCode bool, method, url string, urlParams map[string]string,
 	sObj interface{}, headers ...string) (int, string, error) {
 	sBytes, err := json.Marshal(sObj)
@@ -202,6 +372,16 @@ func SendJSONObjRequestReceiveString(client *http.Client, errSCode bool, method,
 	return SendRequestReceiveString(client, errSCode, method, url, urlParams, sBytes, headers...)
 }
 
+func SendJSONObjRequestReceiveStringCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, errSCode bool,
+	method, url string, urlParams map[string]string, sObj interface{}, headers ...string) (int, string, error) {
+	sBytes, err := json.Marshal(sObj)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return SendRequestReceiveStringCtx(ctx, client, retry, errSCode, method, url, urlParams, sBytes, headers...)
+}
+
 func SendJSONObjRequestReceiveJSONObj(client *http.Client, errSCode bool, method, url string, urlParams map[string]string,
 	sObj interface{}, rObj interface{}, headers ...string) (int, []byte, error) {
 	sBytes, err := json.Marshal(sObj)
@@ -212,6 +392,16 @@ func SendJSONObjRequestReceiveJSONObj(client *http.Client, errSCode bool, method
 	return SendRequestReceiveJSONObj(client, errSCode, method, url, urlParams, sBytes, rObj, headers...)
 }
 
+func SendJSONObjRequestReceiveJSONObjCtx(ctx context.Context, client *http.Client, retry *RetryPolicy, errSCode bool,
+	method, url string, urlParams map[string]string, sObj interface{}, rObj interface{}, headers ...string) (int, []byte, error) {
+	sBytes, err := json.Marshal(sObj)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return SendRequestReceiveJSONObjCtx(ctx, client, retry, errSCode, method, url, urlParams, sBytes, rObj, headers...)
+}
+
 func RetrieveRequestHostURL(r *http.Request) string {
 	scheme := r.Header.Get("X-Forwarded-Proto")
 	if scheme == "" {
@@ -297,6 +487,53 @@ func Respond400(w http.ResponseWriter, err, detail string, extras ...interface{}
 	RespondError(w, 400, err, detail, extras...)
 }
 
+// RespondVErr responds with code and detail of VErr found in the err chain, other errors become internal_error
+func RespondVErr(w http.ResponseWriter, err error) {
+	RespondVErrLocalized(w, err, nil)
+}
+
+// RespondVErrLocalized is RespondVErr with detail translated by t, see VErr.Localize
+func RespondVErrLocalized(w http.ResponseWriter, err error, t lily.TranslateFunc) {
+	var vErrs lily.VErrs
+	if errors.As(err, &vErrs) {
+		RespondVErrsLocalized(w, vErrs, t)
+		return
+	}
+	var vErr *lily.VErr
+	if !errors.As(err, &vErr) {
+		vErr = &lily.VErr{Code: "internal_error", Detail: "Internal server error"}
+	}
+	extras := make([]interface{}, 0, len(vErr.Extras))
+	for _, x := range vErr.Extras {
+		extras = append(extras, x)
+	}
+	RespondError(w, vErr.StatusCode(), vErr.Code, vErr.Localize(t), extras...)
+}
+
+// RespondVErrs responds with all field errors at once:
+// {"error": "validation_failed", "error_dsc": "...", "fields": {"path": {"error": "...", "error_dsc": "..."}}}
+func RespondVErrs(w http.ResponseWriter, errs lily.VErrs) {
+	RespondVErrsLocalized(w, errs, nil)
+}
+
+func RespondVErrsLocalized(w http.ResponseWriter, errs lily.VErrs, t lily.TranslateFunc) {
+	fields := make(map[string]interface{}, len(errs))
+	for field, err := range errs {
+		if err == nil {
+			continue
+		}
+		obj := map[string]interface{}{}
+		obj["error"] = err.Code
+		obj["error_dsc"] = err.Localize(t)
+		for i := 0; (i + 1) < len(err.Extras); i += 2 {
+			obj[err.Extras[i]] = err.Extras[i+1]
+		}
+		fields[field] = obj
+	}
+	vErr := &lily.VErr{Code: "validation_failed", Detail: "Validation failed"}
+	RespondError(w, vErr.StatusCode(), vErr.Code, vErr.Localize(t), "fields", fields)
+}
+
 func Respond401(w http.ResponseWriter, detail string) {
 	RespondError(w, 401, "unauthorized", detail)
 }
@@ -0,0 +1,33 @@
+package http
+
+import (
+	"github.com/rendau/lily"
+	"net/http"
+	"time"
+)
+
+// MwAccessLog writes a log line per request.
+// Usual order is MwRequestID(MwAccessLog(MwRecovery(h))), so panics are logged with 500 status and request id.
+func MwAccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := newResponseWriter(w)
+
+		defer func() {
+			args := []interface{}{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rw.status,
+				"bytes", rw.size,
+				"duration", time.Since(start),
+				"remote_ip", RetrieveRemoteIP(r),
+			}
+			if id := RequestIDFromContext(r.Context()); id != "" {
+				args = append(args, "request_id", id)
+			}
+			lily.Log().Info("http request", args...)
+		}()
+
+		h.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,491 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rendau/lily"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMwCORS(t *testing.T) {
+	called := 0
+	h := MwCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}), &CORSOptions{
+		AllowedOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+		AllowedMethods:        []string{"get", "put"},
+		AllowedHeaders:        []string{"Content-Type", "Authorization"},
+		ExposedHeaders:        []string{"x-total"},
+		AllowCredentials:      true,
+		MaxAge:                600,
+	})
+
+	do := func(method, origin string, headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for i := 0; (i + 1) < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, origin := range []string{"https://example.com", "https://api.example.org", "http://localhost:3000"} {
+		w := do("GET", origin)
+		if w.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Error("origin is not allowed", origin)
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Error("credentials are not allowed")
+		}
+		if w.Header().Get("Access-Control-Expose-Headers") != "X-Total" {
+			t.Error("bad exposed headers")
+		}
+	}
+
+	for _, origin := range []string{"https://evil.com", "https://example.org", "http://api.example.org", "http://localhost"} {
+		w := do("GET", origin)
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Error("origin is allowed", origin)
+		}
+	}
+
+	if called != 7 {
+		t.Error("handler called wrong number of times", called)
+	}
+
+	w := do("GET", "")
+	if len(w.Header()) != 1 || w.Header().Get("Vary") != "Origin" || called != 8 {
+		t.Error("request without origin must get only Vary header")
+	}
+
+	w = do("OPTIONS", "https://example.com",
+		"Access-Control-Request-Method", "PUT",
+		"Access-Control-Request-Headers", "content-type, authorization")
+	if called != 8 {
+		t.Error("handler called for preflight")
+	}
+	if w.Code != http.StatusNoContent ||
+		w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "content-type, authorization" ||
+		w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Error("bad preflight response", w.Code, w.Header())
+	}
+
+	for _, headers := range [][]string{
+		{"Access-Control-Request-Method", "DELETE"},
+		{"Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "x-secret"},
+	} {
+		w = do("OPTIONS", "https://example.com", headers...)
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Error("preflight is allowed", headers)
+		}
+	}
+
+	h = MwCORS(http.NotFoundHandler(), &CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	w = do("GET", "https://any.com")
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("any origin is not allowed")
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("credentials must not be allowed for any origin")
+	}
+
+	h = MwCORS(http.NotFoundHandler(), &CORSOptions{
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`https://app\.example\.com`)},
+		AllowCredentials:      true,
+	})
+	w = do("GET", "https://app.example.com.evil.io")
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("unanchored pattern must match whole origin")
+	}
+	w = do("GET", "https://evil.io/https://app.example.com")
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("unanchored pattern must match whole origin")
+	}
+	w = do("GET", "https://app.example.com")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Error("origin matching pattern is not allowed")
+	}
+
+	h = MwCORS(http.NotFoundHandler(), nil)
+	w = do("GET", "https://app.example.com")
+	if w.Code != http.StatusNotFound || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("nil options must allow no origins", w.Code)
+	}
+}
+
+func TestMwRecovery(t *testing.T) {
+	h := MwRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/started" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		panic("boom")
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError || w.Header().Get(RequestIDHeader) != "req-1" {
+		t.Error("bad response", w.Code, w.Header())
+	}
+	body := map[string]string{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error("fail to parse body", err)
+	}
+	if body["error"] != "internal_error" || body["request_id"] != "req-1" {
+		t.Error("bad body", body)
+	}
+
+	r = httptest.NewRequest("GET", "/started", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Error("started response is changed", w.Code, w.Body.String())
+	}
+
+	headers := redactedHeaders(http.Header{"Authorization": {"Bearer secret"}, "Cookie": {"a=b"}, "Accept": {"*/*"}})
+	if headers["authorization"] != "[REDACTED]" || headers["cookie"] != "[REDACTED]" || headers["accept"] != "*/*" {
+		t.Error("bad redacted headers", headers)
+	}
+}
+
+func TestMwRequestID(t *testing.T) {
+	var ctxID string
+	h := MwRequestID(MwAccessLog(MwRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID = RequestIDFromContext(r.Context())
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		w.Write([]byte("ok"))
+	}))))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if ctxID != "req-1" || w.Header().Get(RequestIDHeader) != "req-1" || w.Body.String() != "ok" {
+		t.Error("request id is not accepted", ctxID, w.Header())
+	}
+
+	r = httptest.NewRequest("GET", "/panic", nil)
+	r.Header.Set(RequestIDHeader, "bad id")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if ctxID == "" || ctxID == "bad id" || w.Header().Get(RequestIDHeader) != ctxID {
+		t.Error("request id is not generated", ctxID, w.Header())
+	}
+	body := map[string]string{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error("fail to parse body", err)
+	}
+	if w.Code != http.StatusInternalServerError || body["request_id"] != ctxID {
+		t.Error("bad response", w.Code, body)
+	}
+}
+
+func TestMwAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	lily.SetLogger(lily.NewJSONLogger(buf, slog.LevelInfo))
+	defer lily.SetLogger(nil)
+
+	h := MwRequestID(MwAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})))
+
+	r := httptest.NewRequest("POST", "/items?x=1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set(RequestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Error("fail to parse log line", err)
+	}
+	if line["method"] != "POST" || line["path"] != "/items" || line["status"] != float64(201) ||
+		line["bytes"] != float64(7) || line["remote_ip"] != "10.0.0.1" || line["request_id"] != "req-1" {
+		t.Error("bad access log line", buf.String())
+	}
+	if _, ok := line["duration"]; !ok {
+		t.Error("duration is not logged")
+	}
+}
+
+func TestSendRequestCtx(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		case "/retry-after":
+			if n < 2 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	retry := &RetryPolicy{MaxAttempts: 3, MinDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	ctx := context.Background()
+
+	sCode, body, err := SendRequestReceiveBytesCtx(ctx, srv.Client(), retry, true, "PUT", srv.URL+"/flaky", nil, []byte("data"))
+	if err != nil || sCode != 200 || string(body) != "data" || atomic.LoadInt32(&hits) != 3 {
+		t.Error("flaky request failed", sCode, string(body), err, atomic.LoadInt32(&hits))
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	sCode, _, _ = SendRequestReceiveBytesCtx(ctx, srv.Client(), retry, false, "POST", srv.URL+"/flaky", nil, nil)
+	if sCode != 503 || atomic.LoadInt32(&hits) != 1 {
+		t.Error("POST is retried", sCode, atomic.LoadInt32(&hits))
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	sCode, _, _ = SendRequestReceiveBytesCtx(ctx, srv.Client(), nil, false, "GET", srv.URL+"/flaky", nil, nil)
+	if sCode != 503 || atomic.LoadInt32(&hits) != 1 {
+		t.Error("request without policy is retried", sCode, atomic.LoadInt32(&hits))
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	start := time.Now()
+	sCode, _, _ = SendRequestReceiveBytesCtx(ctx, srv.Client(), retry, false, "GET", srv.URL+"/retry-after", nil, nil)
+	if sCode != 200 || atomic.LoadInt32(&hits) != 2 || time.Since(start) > 500*time.Millisecond {
+		t.Error("Retry-After is not capped by MaxDelay", sCode, atomic.LoadInt32(&hits), time.Since(start))
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, _, err = SendRequestReceiveBytesCtx(cctx, srv.Client(), retry, false, "GET", srv.URL+"/slow", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || atomic.LoadInt32(&hits) > 1 {
+		t.Error("request is not cancelled", err, atomic.LoadInt32(&hits))
+	}
+
+	if d, ok := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); !ok || d < 59*time.Minute {
+		t.Error("bad http date Retry-After", d, ok)
+	}
+	for i := 1; i < 10; i++ {
+		if d := DefaultRetryPolicy.delay(i, nil); d > DefaultRetryPolicy.MaxDelay || d < DefaultRetryPolicy.MinDelay/2 {
+			t.Error("bad delay of attempt", i, d)
+		}
+	}
+}
+
+func TestRespondVErr(t *testing.T) {
+	w := httptest.NewRecorder()
+	RespondVErr(w, fmt.Errorf("get item: %w", &lily.VErr{Code: "not_found", Detail: "Item not found", Extras: []string{"id", "7"}}))
+	body := map[string]string{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error("fail to parse body", err)
+	}
+	if w.Code != http.StatusNotFound || body["error"] != "not_found" || body["error_dsc"] != "Item not found" || body["id"] != "7" {
+		t.Error("bad VErr response", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	RespondVErr(w, errors.New("db is down"))
+	body = map[string]string{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error("fail to parse body", err)
+	}
+	if w.Code != http.StatusInternalServerError || body["error"] != "internal_error" || strings.Contains(w.Body.String(), "db is down") {
+		t.Error("bad response for not VErr", w.Code, w.Body.String())
+	}
+}
+
+func TestRespondErr(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := RespondJSONObjErr(w, http.StatusCreated, map[string]int{"a": 1}); err != nil {
+		t.Error("fail to respond", err)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "{\"a\":1}\n" || w.Header().Get("Content-Type") == "" {
+		t.Error("bad response", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := RespondJSONObjErr(w, http.StatusCreated, map[string]interface{}{"a": func() {}}); err == nil {
+		t.Error("unencodable object must return error")
+	}
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" || w.Flushed {
+		t.Error("nothing must be written for unencodable object", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := RespondStrErr(w, http.StatusAccepted, "ok"); err != nil || w.Code != http.StatusAccepted || w.Body.String() != "ok" {
+		t.Error("bad string response", err, w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	err := RespondStrErr(w, http.StatusAccepted, "")
+	if err == nil || w.Code != http.StatusOK {
+		t.Error("empty body must return error", err, w.Code)
+	}
+	var cErr *lily.CallerErr
+	if !errors.As(err, &cErr) || !strings.HasSuffix(cErr.File, "test.go") {
+		t.Error("error must have the caller location", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("SendRequest must panic with nil client")
+			}
+		}()
+		SendRequest(nil, "GET", "http://localhost", nil, nil)
+	}()
+	if _, err := SendRequestCtx(context.Background(), nil, nil, "GET", "http://localhost", nil, nil); err == nil {
+		t.Error("SendRequestCtx must return error for nil client")
+	}
+}
+
+func TestRespondVErrs(t *testing.T) {
+	errs := lily.VErrs{}
+	errs.Add("name", "required", "Name is required", nil)
+	errs.Add("items.0.qty", "too_small", "Must be at least {{.Min}}", map[string]string{"Min": "1"})
+	errs["broken"] = nil
+
+	w := httptest.NewRecorder()
+	RespondVErr(w, fmt.Errorf("save: %w", errs.Err()))
+
+	body := struct {
+		Error    string                       `json:"error"`
+		ErrorDsc string                       `json:"error_dsc"`
+		Fields   map[string]map[string]string `json:"fields"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error("fail to parse body", err)
+	}
+	if w.Code != http.StatusBadRequest || body.Error != "validation_failed" || body.ErrorDsc == "" {
+		t.Error("bad response", w.Code, w.Body.String())
+	}
+	if len(body.Fields) != 2 ||
+		body.Fields["name"]["error"] != "required" ||
+		body.Fields["items.0.qty"]["error"] != "too_small" ||
+		body.Fields["items.0.qty"]["error_dsc"] != "Must be at least 1" {
+		t.Error("bad fields", w.Body.String())
+	}
+}
+
+type countingReporter struct {
+	reports []*lily.PanicReport
+}
+
+func (r *countingReporter) Report(report *lily.PanicReport) error {
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+func TestMwRecoveryReport(t *testing.T) {
+	rep := &countingReporter{}
+	lily.SetReporter(rep)
+	defer lily.SetReporter(nil)
+
+	h := MwRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			lily.ErrPanicWS(errors.New("boom"))
+		}
+		panic("plain")
+	}))
+
+	buf := &bytes.Buffer{}
+	lily.SetLogger(lily.NewJSONLogger(buf, slog.LevelInfo))
+	defer lily.SetLogger(nil)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws", nil))
+	if len(rep.reports) != 1 {
+		t.Error("ErrPanicWS panic must be reported once", len(rep.reports))
+	} else {
+		report := rep.reports[0]
+		if report.Error != "boom" || report.Request == nil || report.Request.URL != "/ws" ||
+			!strings.Contains(report.Stack, "TestMwRecoveryReport") {
+			t.Error("ErrPanicWS panic must be reported with request and its stack", report)
+		}
+	}
+	if n := strings.Count(buf.String(), `"stack"`); n != 1 {
+		t.Error("ErrPanicWS panic must be logged once", n, buf.String())
+	}
+	if _, ok := lily.TakePendingPanic(""); ok {
+		t.Error("bad pending panic")
+	}
+
+	rep.reports = nil
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/plain", nil))
+	if len(rep.reports) != 1 || rep.reports[0].Request == nil || rep.reports[0].Request.URL != "/plain" {
+		t.Error("handler panic must be reported once with request", len(rep.reports))
+	}
+}
+
+func TestSendRequestCtxErrors(t *testing.T) {
+	retry := &RetryPolicy{MaxAttempts: 3, MinDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	var hits int32
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer tlsSrv.Close()
+
+	// default client does not trust the test certificate
+	_, err := SendRequestCtx(context.Background(), &http.Client{}, retry, "GET", tlsSrv.URL, nil, nil)
+	if err == nil || isConnectionErr(err) {
+		t.Error("tls verification error must not be retried", err)
+	}
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Error("request must not reach the server")
+	}
+
+	_, err = SendRequestCtx(context.Background(), &http.Client{}, retry, "GET", "ftp://localhost/x", nil, nil)
+	if err == nil || isConnectionErr(err) {
+		t.Error("unsupported scheme must not be retried", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error("fail to listen", err)
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, err = SendRequestCtx(context.Background(), &http.Client{}, retry, "GET", "http://"+addr, nil, nil)
+	if err == nil || !isConnectionErr(err) {
+		t.Error("refused connection must be retried", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = SendRequestCtx(ctx, &http.Client{}, retry, "GET", tlsSrv.URL, nil, nil)
+	if err == nil || isConnectionErr(err) {
+		t.Error("context error must not be retried", err)
+	}
+}
@@ -0,0 +1,88 @@
+package lily
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Logger is the leveled structured logger used by all lily packages,
+// args are key-value pairs like in log/slog
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type loggerHolder struct {
+	l Logger
+}
+
+var logger atomic.Pointer[loggerHolder]
+
+// SetLogger is expected to be called once at startup, nil restores the default logger
+func SetLogger(l Logger) {
+	if l == nil {
+		logger.Store(nil)
+		return
+	}
+	logger.Store(&loggerHolder{l: l})
+}
+
+// Log returns the configured logger, by default it is slog.Default(), which writes through the standard log package
+func Log() Logger {
+	if h := logger.Load(); h != nil {
+		return h.l
+	}
+	return defaultLogger{}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+// NewJSONLogger writes one JSON object per line
+func NewJSONLogger(w io.Writer, level slog.Level) Logger {
+	return NewSlogLogger(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+}
+
+func (l *slogLogger) Debug(msg string, args ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelDebug, msg, args...)
+}
+
+func (l *slogLogger) Info(msg string, args ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelInfo, msg, args...)
+}
+
+func (l *slogLogger) Warn(msg string, args ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelWarn, msg, args...)
+}
+
+func (l *slogLogger) Error(msg string, args ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelError, msg, args...)
+}
+
+// defaultLogger follows slog.SetDefault changes
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, args ...interface{}) {
+	slog.Default().Log(context.Background(), slog.LevelDebug, msg, args...)
+}
+
+func (defaultLogger) Info(msg string, args ...interface{}) {
+	slog.Default().Log(context.Background(), slog.LevelInfo, msg, args...)
+}
+
+func (defaultLogger) Warn(msg string, args ...interface{}) {
+	slog.Default().Log(context.Background(), slog.LevelWarn, msg, args...)
+}
+
+func (defaultLogger) Error(msg string, args ...interface{}) {
+	slog.Default().Log(context.Background(), slog.LevelError, msg, args...)
+}
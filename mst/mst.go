@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/nicksnyder/go-i18n/i18n"
+	"github.com/rendau/lily"
 	lilyHttp "github.com/rendau/lily/http"
 	"net/http"
 	"time"
@@ -96,3 +97,12 @@ func RespondServiceNA(w http.ResponseWriter, ctx *RequestUserCTXSt) {
 		lilyHttp.Respond400(w, "service_na", ctx.T("Sorry, service is temporarily unavailable"))
 	}
 }
+
+// RespondVErr responds with VErr detail translated to the user language
+func RespondVErr(w http.ResponseWriter, ctx *RequestUserCTXSt, err error) {
+	if ctx == nil || ctx.T == nil {
+		lilyHttp.RespondVErr(w, err)
+	} else {
+		lilyHttp.RespondVErrLocalized(w, err, lily.TranslateFunc(ctx.T))
+	}
+}
@@ -166,6 +166,24 @@ func DeferHandleTxn(txn *sqlx.Tx) {
 	}
 }
 
+// DeferHandleTxnErr is DeferHandleTxn for functions returning error, use it as
+// defer DeferHandleTxnErr(txn, &err). Transaction is rolled back when *errp is not nil,
+// commit failure is set to *errp with location of the function instead of panic.
+func DeferHandleTxnErr(txn *sqlx.Tx, errp *error) {
+	if p := recover(); p != nil {
+		txn.Rollback()
+		panic(p)
+	}
+	if *errp != nil {
+		txn.Rollback()
+		return
+	}
+	err := txn.Commit()
+	if err != nil && err != sql.ErrTxDone {
+		*errp = lily.ErrWrapCaller(err)
+	}
+}
+
 func TransactionWithTimezone(db *sqlx.DB, tzHOffset int) (error, *sqlx.Tx) {
 	tx, err := db.Beginx()
 	lily.ErrPanicSilent(err)
@@ -179,6 +197,22 @@ func TransactionWithTimezone(db *sqlx.DB, tzHOffset int) (error, *sqlx.Tx) {
 	return nil, tx
 }
 
+// TransactionWithTimezoneErr is TransactionWithTimezone, which returns errors with the caller location instead of panic
+func TransactionWithTimezoneErr(db *sqlx.DB, tzHOffset int) (error, *sqlx.Tx) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return lily.ErrWrapCaller(err), nil
+	}
+
+	_, err = tx.Exec(`set local time zone ` + strconv.Itoa(tzHOffset))
+	if err != nil {
+		tx.Rollback()
+		return lily.ErrWrapCaller(err), nil
+	}
+
+	return nil, tx
+}
+
 func JsonListQuery(q string) string {
 	return `(select coalesce(array_to_json(array_agg(row_to_json(sq.*))), '[]')
 		from (` + q + `) sq)`
@@ -0,0 +1,299 @@
+package lily
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PanicReport describes recovered panic, Request is nil for panics outside http handlers
+type PanicReport struct {
+	Time    time.Time      `json:"time"`
+	Error   string         `json:"error"`
+	Stack   string         `json:"stack"`
+	Request *ReportRequest `json:"request,omitempty"`
+}
+
+type ReportRequest struct {
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	RemoteIP string            `json:"remote_ip,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// Reporter delivers panic reports somewhere outside of the log, it must be safe for concurrent use
+type Reporter interface {
+	Report(r *PanicReport) error
+}
+
+type reporterHolder struct {
+	r Reporter
+}
+
+var reporter atomic.Pointer[reporterHolder]
+
+// SetReporter sets reporter for ErrPanicWS and http.MwRecovery, nil disables reporting
+func SetReporter(r Reporter) {
+	if r == nil {
+		reporter.Store(nil)
+		return
+	}
+	reporter.Store(&reporterHolder{r: r})
+}
+
+// ReportPanic passes panic to the configured reporter, failures of reporter are logged
+func ReportPanic(err interface{}, stack []byte, req *ReportRequest) {
+	h := reporter.Load()
+	if h == nil {
+		return
+	}
+	rErr := h.r.Report(&PanicReport{
+		Time:    time.Now().UTC(),
+		Error:   fmt.Sprint(err),
+		Stack:   string(stack),
+		Request: req,
+	})
+	if rErr != nil {
+		Log().Warn("fail to report panic", "error", rErr)
+	}
+}
+
+// PendingPanic is a panic of ErrPanicWS, which is not logged and reported yet,
+// because a registered recoverer is on the stack and will do it
+type PendingPanic struct {
+	Err   error
+	Msg   string
+	Stack string
+}
+
+const maxPendingPanics = 1000
+
+var (
+	recoverersMu sync.RWMutex
+	recoverers   []string
+
+	pendingMu sync.Mutex
+	pending   = map[string]*PendingPanic{}
+)
+
+// RegisterRecoverer registers function by its full name like "github.com/rendau/lily/http.MwRecovery".
+// Panics of ErrPanicWS under the function are left to it, it must take them by TakePendingPanic.
+func RegisterRecoverer(name string) {
+	recoverersMu.Lock()
+	recoverers = append(recoverers, name)
+	recoverersMu.Unlock()
+}
+
+// TakePendingPanic returns pending panic for the recovered value of ErrPanicWS
+func TakePendingPanic(v interface{}) (*PendingPanic, bool) {
+	key, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	p, ok := pending[key]
+	if ok {
+		delete(pending, key)
+	}
+	return p, ok
+}
+
+// deferPanic keeps p for the recoverer, if there is one on the stack of the current goroutine
+func deferPanic(value string, p *PendingPanic) bool {
+	if !underRecoverer() {
+		return false
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	// values, which were recovered by somebody else, are never taken, so they are limited
+	if len(pending) >= maxPendingPanics {
+		return false
+	}
+	pending[value] = p
+	return true
+}
+
+func underRecoverer() bool {
+	recoverersMu.RLock()
+	defer recoverersMu.RUnlock()
+	if len(recoverers) == 0 {
+		return false
+	}
+
+	pcs := make([]uintptr, 512)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		for _, name := range recoverers {
+			if frame.Function == name || strings.HasPrefix(frame.Function, name+".") {
+				return true
+			}
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// FileReporter appends reports to file as JSON lines
+type FileReporter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFileReporter(path string) (*FileReporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReporter{f: f}, nil
+}
+
+func (r *FileReporter) Report(report *PanicReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.f.Write(append(data, '\n'))
+	return err
+}
+
+func (r *FileReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// SentryReporter sends reports as events in sentry envelope format
+type SentryReporter struct {
+	client      *http.Client
+	dsn         string
+	endpoint    string
+	auth        string
+	Environment string
+	Release     string
+}
+
+// SentryTimeout limits sending of a report by the default client of SentryReporter,
+// reports are sent in the recovery path, so they must not hang the failed request
+var SentryTimeout = 5 * time.Second
+
+// NewSentryReporter parses dsn like https://public_key@host/project_id,
+// client with SentryTimeout is used for nil client
+func NewSentryReporter(dsn string, client *http.Client) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sentry dsn has no public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	idx := strings.LastIndex(path, "/")
+	if idx < 0 || idx == len(path)-1 {
+		return nil, errors.New("sentry dsn has no project id")
+	}
+	projectID := path[idx+1:]
+
+	if client == nil {
+		client = &http.Client{Timeout: SentryTimeout}
+	}
+
+	return &SentryReporter{
+		client:   client,
+		dsn:      dsn,
+		endpoint: u.Scheme + "://" + u.Host + path[:idx] + "/api/" + projectID + "/envelope/",
+		auth:     "Sentry sentry_version=7, sentry_client=lily/1.0, sentry_key=" + u.User.Username(),
+	}, nil
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (r *SentryReporter) Report(report *PanicReport) error {
+	eventID := newEventID()
+
+	event := map[string]interface{}{
+		"event_id":  eventID,
+		"timestamp": report.Time.Format(time.RFC3339Nano),
+		"platform":  "go",
+		"level":     "fatal",
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{
+				{"type": "panic", "value": report.Error},
+			},
+		},
+		"extra": map[string]interface{}{
+			"stack": report.Stack,
+		},
+	}
+	if r.Environment != "" {
+		event["environment"] = r.Environment
+	}
+	if r.Release != "" {
+		event["release"] = r.Release
+	}
+	if report.Request != nil {
+		event["request"] = map[string]interface{}{
+			"method":  report.Request.Method,
+			"url":     report.Request.URL,
+			"headers": report.Request.Headers,
+			"env":     map[string]string{"REMOTE_ADDR": report.Request.RemoteIP},
+		}
+	}
+
+	eventData, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(map[string]string{
+		"event_id": eventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+		"dsn":      r.dsn,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := &bytes.Buffer{}
+	body.Write(header)
+	fmt.Fprintf(body, "\n{\"type\":\"event\",\"length\":%d}\n", len(eventData))
+	body.Write(eventData)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest("POST", r.endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sentry responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
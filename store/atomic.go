@@ -0,0 +1,235 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+var ErrNotNumeric = errors.New("store: value is not numeric or has different type")
+
+type computeCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// update must be called under Lock, it keeps expiration of the item
+func (s *TypedStore[K, V]) update(key K, item *TypedItem[V], value V) {
+	s.stats.sets.Add(1)
+	s.evict(key, item, EvictionReplaced)
+	cost := item.cost
+	if s.costFunc != nil {
+		cost = s.costFunc(key, value)
+	}
+	s.cost += cost - item.cost
+	s.publish(EventSet, key, value)
+	s.store[key] = &TypedItem[V]{
+		value:      value,
+		duration:   item.duration,
+		expiration: item.expiration,
+		cost:       cost,
+		tags:       item.tags,
+	}
+	if s.policy != nil {
+		s.policy.Accessed(key)
+		s.enforceCapacity(0, 0)
+	}
+}
+
+// GetOrSet returns existing value, or sets the given one. Loaded is true if the value was found
+func (s *TypedStore[K, V]) GetOrSet(key K, value V, duration time.Duration) (actual V, loaded bool) {
+	if actual, loaded = s.Get(key, false); loaded {
+		return actual, true
+	}
+	s.Set(key, value, duration)
+	return value, false
+}
+
+func (s *TypedStore[K, V]) GetOrSetLock(key K, value V, duration time.Duration) (V, bool) {
+	s.Lock()
+	actual, loaded := s.GetOrSet(key, value, duration)
+	s.Unlock()
+	return actual, loaded
+}
+
+// GetOrCompute returns existing value, or calls loader and sets its result.
+// Loader is called outside the lock, and only once for concurrent calls with the same key.
+// Errors are not cached.
+func (s *TypedStore[K, V]) GetOrCompute(key K, duration time.Duration, loader func() (V, error)) (V, error) {
+	s.Lock()
+	if value, found := s.Get(key, false); found {
+		s.Unlock()
+		return value, nil
+	}
+	if call, found := s.calls[key]; found {
+		s.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &computeCall[V]{done: make(chan struct{})}
+	if s.calls == nil {
+		s.calls = make(map[K]*computeCall[V])
+	}
+	s.calls[key] = call
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		delete(s.calls, key)
+		if call.err == nil {
+			s.Set(key, call.value, duration)
+		}
+		s.Unlock()
+		close(call.done)
+	}()
+
+	// waiters get this error if loader panics
+	call.err = errors.New("store: loader panicked")
+	call.value, call.err = loader()
+
+	return call.value, call.err
+}
+
+// Increment adds delta to numeric value, absent value is set to delta with the given duration
+func (s *TypedStore[K, V]) Increment(key K, delta V, duration time.Duration) (V, error) {
+	return s.add(key, delta, false, duration)
+}
+
+func (s *TypedStore[K, V]) IncrementLock(key K, delta V, duration time.Duration) (V, error) {
+	s.Lock()
+	value, err := s.Increment(key, delta, duration)
+	s.Unlock()
+	return value, err
+}
+
+// Decrement subtracts delta from numeric value, absent value is set to -delta with the given duration
+func (s *TypedStore[K, V]) Decrement(key K, delta V, duration time.Duration) (V, error) {
+	return s.add(key, delta, true, duration)
+}
+
+func (s *TypedStore[K, V]) DecrementLock(key K, delta V, duration time.Duration) (V, error) {
+	s.Lock()
+	value, err := s.Decrement(key, delta, duration)
+	s.Unlock()
+	return value, err
+}
+
+func (s *TypedStore[K, V]) add(key K, delta V, negative bool, duration time.Duration) (V, error) {
+	var zero V
+
+	current, found := s.Get(key, false)
+	if !found {
+		result, err := addNumber(zeroOf(delta), delta, negative)
+		if err != nil {
+			return zero, err
+		}
+		s.Set(key, result.(V), duration)
+		return result.(V), nil
+	}
+
+	result, err := addNumber(current, delta, negative)
+	if err != nil {
+		return zero, err
+	}
+	s.update(key, s.store[key], result.(V))
+
+	return result.(V), nil
+}
+
+// CompareAndSwap sets new value only if the current one equals to old
+func (s *TypedStore[K, V]) CompareAndSwap(key K, old, new V) bool {
+	current, found := s.Get(key, false)
+	if !found || !equal(current, old) {
+		return false
+	}
+	s.update(key, s.store[key], new)
+	return true
+}
+
+func (s *TypedStore[K, V]) CompareAndSwapLock(key K, old, new V) bool {
+	s.Lock()
+	swapped := s.CompareAndSwap(key, old, new)
+	s.Unlock()
+	return swapped
+}
+
+// Update replaces value with result of fn, or deletes it if fn returns keep=false.
+// Existing item keeps its expiration, new one gets the default duration.
+// Fn is called under the lock and must not use the store.
+func (s *TypedStore[K, V]) Update(key K, fn func(value V, found bool) (newValue V, keep bool)) (V, bool) {
+	current, found := s.Get(key, false)
+	value, keep := fn(current, found)
+	switch {
+	case !keep:
+		s.Delete(key)
+	case found:
+		s.update(key, s.store[key], value)
+	default:
+		s.Set(key, value, StoreDefaultDuration)
+	}
+	return value, keep
+}
+
+func (s *TypedStore[K, V]) UpdateLock(key K, fn func(value V, found bool) (newValue V, keep bool)) (V, bool) {
+	s.Lock()
+	value, keep := s.Update(key, fn)
+	s.Unlock()
+	return value, keep
+}
+
+func equal[V any](a, b V) bool {
+	x, y := interface{}(a), interface{}(b)
+	if x == nil || y == nil {
+		return x == y
+	}
+	if reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return false
+	}
+	if !reflect.TypeOf(x).Comparable() {
+		return reflect.DeepEqual(x, y)
+	}
+	return x == y
+}
+
+func zeroOf(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return reflect.Zero(reflect.TypeOf(v)).Interface()
+}
+
+// addNumber works by kind, so named numeric types like time.Duration are supported too
+func addNumber(value, delta interface{}, negative bool) (interface{}, error) {
+	if value == nil || delta == nil {
+		return nil, ErrNotNumeric
+	}
+	x, y := reflect.ValueOf(value), reflect.ValueOf(delta)
+	if x.Type() != y.Type() {
+		return nil, ErrNotNumeric
+	}
+	result := reflect.New(x.Type()).Elem()
+	switch x.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if negative {
+			result.SetInt(x.Int() - y.Int())
+		} else {
+			result.SetInt(x.Int() + y.Int())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if negative {
+			result.SetUint(x.Uint() - y.Uint())
+		} else {
+			result.SetUint(x.Uint() + y.Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		if negative {
+			result.SetFloat(x.Float() - y.Float())
+		} else {
+			result.SetFloat(x.Float() + y.Float())
+		}
+	default:
+		return nil, ErrNotNumeric
+	}
+	return result.Interface(), nil
+}
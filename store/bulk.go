@@ -0,0 +1,222 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// keyHasPrefix matches string keys directly, other keys by their fmt representation
+func keyHasPrefix[K comparable](key K, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	switch k := interface{}(key).(type) {
+	case string:
+		return strings.HasPrefix(k, prefix)
+	case fmt.Stringer:
+		return strings.HasPrefix(k.String(), prefix)
+	}
+	return strings.HasPrefix(fmt.Sprint(key), prefix)
+}
+
+// Range calls fn for every not expired item until it returns false. Fn must not use the store
+func (s *TypedStore[K, V]) Range(fn func(key K, value V) bool) {
+	now := s.clock.Now().UnixNano()
+	for key, item := range s.store {
+		if item.expired(now) {
+			continue
+		}
+		if !fn(key, item.value) {
+			return
+		}
+	}
+}
+
+func (s *TypedStore[K, V]) RangeLock(fn func(key K, value V) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.Range(fn)
+}
+
+func (s *TypedStore[K, V]) Keys(prefix string) []K {
+	var keys []K
+	s.Range(func(key K, value V) bool {
+		if keyHasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+func (s *TypedStore[K, V]) KeysLock(prefix string) []K {
+	s.mu.RLock()
+	keys := s.Keys(prefix)
+	s.mu.RUnlock()
+	return keys
+}
+
+func (s *TypedStore[K, V]) GetByPrefix(prefix string) []*TypedListRow[K, V] {
+	var rows []*TypedListRow[K, V]
+	s.Range(func(key K, value V) bool {
+		if keyHasPrefix(key, prefix) {
+			rows = append(rows, &TypedListRow[K, V]{Key: key, Value: value})
+		}
+		return true
+	})
+	return rows
+}
+
+func (s *TypedStore[K, V]) GetByPrefixLock(prefix string) []*TypedListRow[K, V] {
+	s.mu.RLock()
+	rows := s.GetByPrefix(prefix)
+	s.mu.RUnlock()
+	return rows
+}
+
+// DeleteByPrefix returns count of deleted items
+func (s *TypedStore[K, V]) DeleteByPrefix(prefix string) int {
+	keys := s.Keys(prefix)
+	for _, key := range keys {
+		s.Delete(key)
+	}
+	return len(keys)
+}
+
+func (s *TypedStore[K, V]) DeleteByPrefixLock(prefix string) int {
+	s.Lock()
+	cnt := s.DeleteByPrefix(prefix)
+	s.Unlock()
+	return cnt
+}
+
+func (s *TypedStore[K, V]) SetMany(items map[K]V, duration time.Duration) {
+	for key, value := range items {
+		s.Set(key, value, duration)
+	}
+}
+
+func (s *TypedStore[K, V]) SetManyLock(items map[K]V, duration time.Duration) {
+	s.Lock()
+	s.SetMany(items, duration)
+	s.Unlock()
+}
+
+// GetMany returns only found items
+func (s *TypedStore[K, V]) GetMany(keys []K) map[K]V {
+	result := make(map[K]V, len(keys))
+	for _, key := range keys {
+		if value, found := s.Get(key, false); found {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func (s *TypedStore[K, V]) GetManyLock(keys []K) map[K]V {
+	s.mu.RLock()
+	if s.policy == nil {
+		result := make(map[K]V, len(keys))
+		for _, key := range keys {
+			if value, found := s.peek(key); found {
+				result[key] = value
+			}
+		}
+		s.mu.RUnlock()
+		return result
+	}
+	s.mu.RUnlock()
+	s.Lock()
+	result := s.GetMany(keys)
+	s.Unlock()
+	return result
+}
+
+func (s *TypedStore[K, V]) DeleteMany(keys []K) {
+	for _, key := range keys {
+		s.Delete(key)
+	}
+}
+
+func (s *TypedStore[K, V]) DeleteManyLock(keys []K) {
+	s.Lock()
+	s.DeleteMany(keys)
+	s.Unlock()
+}
+
+func (s *ShardedStore[K, V]) RangeLock(fn func(key K, value V) bool) {
+	for _, shard := range s.shards {
+		stop := false
+		shard.RangeLock(func(key K, value V) bool {
+			stop = !fn(key, value)
+			return !stop
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+func (s *ShardedStore[K, V]) KeysLock(prefix string) []K {
+	var keys []K
+	for _, shard := range s.shards {
+		keys = append(keys, shard.KeysLock(prefix)...)
+	}
+	return keys
+}
+
+func (s *ShardedStore[K, V]) GetByPrefixLock(prefix string) []*TypedListRow[K, V] {
+	var rows []*TypedListRow[K, V]
+	for _, shard := range s.shards {
+		rows = append(rows, shard.GetByPrefixLock(prefix)...)
+	}
+	return rows
+}
+
+func (s *ShardedStore[K, V]) DeleteByPrefixLock(prefix string) int {
+	cnt := 0
+	for _, shard := range s.shards {
+		cnt += shard.DeleteByPrefixLock(prefix)
+	}
+	return cnt
+}
+
+func (s *ShardedStore[K, V]) SetManyLock(items map[K]V, duration time.Duration) {
+	byShard := make(map[*TypedStore[K, V]]map[K]V)
+	for key, value := range items {
+		shard := s.shard(key)
+		if byShard[shard] == nil {
+			byShard[shard] = make(map[K]V)
+		}
+		byShard[shard][key] = value
+	}
+	for shard, shardItems := range byShard {
+		shard.SetManyLock(shardItems, duration)
+	}
+}
+
+func (s *ShardedStore[K, V]) GetManyLock(keys []K) map[K]V {
+	result := make(map[K]V, len(keys))
+	for shard, shardKeys := range s.groupKeys(keys) {
+		for key, value := range shard.GetManyLock(shardKeys) {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func (s *ShardedStore[K, V]) DeleteManyLock(keys []K) {
+	for shard, shardKeys := range s.groupKeys(keys) {
+		shard.DeleteManyLock(shardKeys)
+	}
+}
+
+func (s *ShardedStore[K, V]) groupKeys(keys []K) map[*TypedStore[K, V]][]K {
+	byShard := make(map[*TypedStore[K, V]][]K)
+	for _, key := range keys {
+		shard := s.shard(key)
+		byShard[shard] = append(byShard[shard], key)
+	}
+	return byShard
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"time"
+)
+
+// Cache is the common interface of in-memory and remote stores.
+// Remote backends may fail, so every method returns error.
+type Cache[K comparable, V any] interface {
+	Set(key K, value V, duration time.Duration) error
+	Get(key K, refreshExpiration bool) (V, bool, error)
+	Delete(key K) error
+	RefreshExpiration(key K) error
+	GetAll() ([]*TypedListRow[K, V], error)
+}
+
+// LockingStore is implemented by TypedStore and ShardedStore
+type LockingStore[K comparable, V any] interface {
+	SetLock(key K, value V, duration time.Duration)
+	GetLock(key K, refreshExpiration bool) (V, bool)
+	DeleteLock(key K)
+	RefreshExpirationLock(key K)
+	GetAllLock() []*TypedListRow[K, V]
+}
+
+type memCache[K comparable, V any] struct {
+	s LockingStore[K, V]
+}
+
+// AsCache wraps in-memory store into Cache, all operations take the store lock
+func AsCache[K comparable, V any](s LockingStore[K, V]) Cache[K, V] {
+	return &memCache[K, V]{s: s}
+}
+
+func (c *memCache[K, V]) Set(key K, value V, duration time.Duration) error {
+	c.s.SetLock(key, value, duration)
+	return nil
+}
+
+func (c *memCache[K, V]) Get(key K, refreshExpiration bool) (V, bool, error) {
+	value, found := c.s.GetLock(key, refreshExpiration)
+	return value, found, nil
+}
+
+func (c *memCache[K, V]) Delete(key K) error {
+	c.s.DeleteLock(key)
+	return nil
+}
+
+func (c *memCache[K, V]) RefreshExpiration(key K) error {
+	c.s.RefreshExpirationLock(key)
+	return nil
+}
+
+func (c *memCache[K, V]) GetAll() ([]*TypedListRow[K, V], error) {
+	return c.s.GetAllLock(), nil
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy chooses the item to drop when the store exceeds its capacity.
+// It is always called under the store lock.
+type EvictionPolicy[K comparable] interface {
+	Added(key K)
+	Accessed(key K)
+	Removed(key K)
+	Victim() (K, bool)
+}
+
+type lruPolicy[K comparable] struct {
+	ll    *list.List
+	items map[K]*list.Element
+}
+
+// NewLRU evicts the least recently used item
+func NewLRU[K comparable]() EvictionPolicy[K] {
+	return &lruPolicy[K]{
+		ll:    list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+func (p *lruPolicy[K]) Added(key K) {
+	if el, found := p.items[key]; found {
+		p.ll.MoveToFront(el)
+		return
+	}
+	p.items[key] = p.ll.PushFront(key)
+}
+
+func (p *lruPolicy[K]) Accessed(key K) {
+	if el, found := p.items[key]; found {
+		p.ll.MoveToFront(el)
+	}
+}
+
+func (p *lruPolicy[K]) Removed(key K) {
+	if el, found := p.items[key]; found {
+		p.ll.Remove(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *lruPolicy[K]) Victim() (K, bool) {
+	el := p.ll.Back()
+	if el == nil {
+		var zero K
+		return zero, false
+	}
+	return el.Value.(K), true
+}
+
+type lfuEntry[K comparable] struct {
+	key   K
+	freq  uint64
+	seq   uint64
+	index int
+}
+
+type lfuHeap[K comparable] []*lfuEntry[K]
+
+func (h lfuHeap[K]) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].freq < h[j].freq
+}
+
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K]) Push(x interface{}) {
+	e := x.(*lfuEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap[K]) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+type lfuPolicy[K comparable] struct {
+	h     lfuHeap[K]
+	items map[K]*lfuEntry[K]
+	seq   uint64
+}
+
+// NewLFU evicts the least frequently used item, the least recently used one among equals
+func NewLFU[K comparable]() EvictionPolicy[K] {
+	return &lfuPolicy[K]{
+		items: make(map[K]*lfuEntry[K]),
+	}
+}
+
+func (p *lfuPolicy[K]) Added(key K) {
+	if _, found := p.items[key]; found {
+		p.Accessed(key)
+		return
+	}
+	p.seq++
+	e := &lfuEntry[K]{key: key, freq: 1, seq: p.seq}
+	p.items[key] = e
+	heap.Push(&p.h, e)
+}
+
+func (p *lfuPolicy[K]) Accessed(key K) {
+	if e, found := p.items[key]; found {
+		p.seq++
+		e.freq++
+		e.seq = p.seq
+		heap.Fix(&p.h, e.index)
+	}
+}
+
+func (p *lfuPolicy[K]) Removed(key K) {
+	if e, found := p.items[key]; found {
+		heap.Remove(&p.h, e.index)
+		delete(p.items, key)
+	}
+}
+
+func (p *lfuPolicy[K]) Victim() (K, bool) {
+	if len(p.h) == 0 {
+		var zero K
+		return zero, false
+	}
+	return p.h[0].key, true
+}
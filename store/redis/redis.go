@@ -0,0 +1,200 @@
+package redis
+
+import (
+	"bytes"
+	"github.com/rendau/lily/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envelope keeps item duration next to the value, it is required to refresh expiration
+type envelope[V any] struct {
+	Duration time.Duration
+	Value    V
+}
+
+// Cache implements store.Cache[string, V] on top of redis, all keys are prefixed with namespace
+type Cache[V any] struct {
+	client          *Client
+	prefix          string
+	defaultDuration time.Duration
+	codec           store.Codec
+}
+
+var _ store.Cache[string, interface{}] = (*Cache[interface{}])(nil)
+
+// New creates cache, codec is store.GobCodec when nil
+func New[V any](client *Client, prefix string, defaultDuration time.Duration, codec store.Codec) *Cache[V] {
+	if defaultDuration == store.StoreDefaultDuration {
+		defaultDuration = store.StoreNoExpiration
+	}
+	if codec == nil {
+		codec = store.GobCodec
+	}
+	return &Cache[V]{
+		client:          client,
+		prefix:          prefix,
+		defaultDuration: defaultDuration,
+		codec:           codec,
+	}
+}
+
+func (c *Cache[V]) encode(value V, duration time.Duration) (string, error) {
+	buf := &bytes.Buffer{}
+	err := c.codec.NewEncoder(buf).Encode(&envelope[V]{Duration: duration, Value: value})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *Cache[V]) decode(data []byte) (*envelope[V], error) {
+	env := &envelope[V]{}
+	err := c.codec.NewDecoder(bytes.NewReader(data)).Decode(env)
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func milliseconds(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func (c *Cache[V]) Set(key string, value V, duration time.Duration) error {
+	if duration == store.StoreDefaultDuration {
+		duration = c.defaultDuration
+	}
+	data, err := c.encode(value, duration)
+	if err != nil {
+		return err
+	}
+	if duration > 0 {
+		_, err = c.client.Do("SET", c.prefix+key, data, "PX", milliseconds(duration))
+	} else {
+		_, err = c.client.Do("SET", c.prefix+key, data)
+	}
+	return err
+}
+
+func (c *Cache[V]) get(key string) (*envelope[V], error) {
+	reply, err := c.client.Do("GET", c.prefix+key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, errBadReply
+	}
+	return c.decode(data)
+}
+
+func (c *Cache[V]) Get(key string, refreshExpiration bool) (V, bool, error) {
+	var zero V
+
+	env, err := c.get(key)
+	if err != nil || env == nil {
+		return zero, false, err
+	}
+
+	if refreshExpiration && env.Duration > 0 {
+		_, err = c.client.Do("PEXPIRE", c.prefix+key, milliseconds(env.Duration))
+		if err != nil {
+			return zero, false, err
+		}
+	}
+
+	return env.Value, true, nil
+}
+
+func (c *Cache[V]) Delete(key string) error {
+	_, err := c.client.Do("DEL", c.prefix+key)
+	return err
+}
+
+func (c *Cache[V]) RefreshExpiration(key string) error {
+	_, _, err := c.Get(key, true)
+	return err
+}
+
+// GetAll scans keys by namespace, so it is as expensive as on the redis side
+func (c *Cache[V]) GetAll() ([]*store.TypedListRow[string, V], error) {
+	var all []*store.TypedListRow[string, V]
+
+	cursor := "0"
+	for {
+		reply, err := c.client.Do("SCAN", cursor, "MATCH", escapePattern(c.prefix)+"*", "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, errBadReply
+		}
+		next, ok := parts[0].([]byte)
+		if !ok {
+			return nil, errBadReply
+		}
+		keys, ok := parts[1].([]interface{})
+		if !ok {
+			return nil, errBadReply
+		}
+
+		if len(keys) > 0 {
+			args := make([]string, 0, len(keys)+1)
+			args = append(args, "MGET")
+			for _, k := range keys {
+				kb, ok := k.([]byte)
+				if !ok {
+					return nil, errBadReply
+				}
+				args = append(args, string(kb))
+			}
+			reply, err = c.client.Do(args...)
+			if err != nil {
+				return nil, err
+			}
+			values, ok := reply.([]interface{})
+			if !ok || len(values) != len(keys) {
+				return nil, errBadReply
+			}
+			for i, v := range values {
+				data, ok := v.([]byte)
+				if !ok {
+					// expired or deleted after scan
+					continue
+				}
+				env, err := c.decode(data)
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, &store.TypedListRow[string, V]{
+					Key:   strings.TrimPrefix(args[i+1], c.prefix),
+					Value: env.Value,
+				})
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" {
+			return all, nil
+		}
+	}
+}
+
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
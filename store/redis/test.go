@@ -0,0 +1,226 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/rendau/lily/store"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubServer implements tiny subset of RESP commands, enough for Cache.
+// Expiration uses clock, so tests advance it instead of sleeping.
+type stubServer struct {
+	ln      net.Listener
+	clock   *store.FakeClock
+	mu      sync.Mutex
+	data    map[string]string
+	expires map[string]time.Time
+}
+
+func newStubServer(t *testing.T) *stubServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubServer{
+		ln:      ln,
+		clock:   store.NewFakeClock(time.Now()),
+		data:    map[string]string{},
+		expires: map[string]time.Time{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *stubServer) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			return
+		}
+		items := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = string(item.([]byte))
+		}
+		s.mu.Lock()
+		_, err = conn.Write([]byte(s.exec(args)))
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func bulk(v string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+}
+
+func (s *stubServer) get(key string) (string, bool) {
+	if exp, ok := s.expires[key]; ok && !s.clock.Now().Before(exp) {
+		delete(s.data, key)
+		delete(s.expires, key)
+	}
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func (s *stubServer) exec(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		s.data[args[1]] = args[2]
+		delete(s.expires, args[1])
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			s.expires[args[1]] = s.clock.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "GET":
+		if v, ok := s.get(args[1]); ok {
+			return bulk(v)
+		}
+		return "$-1\r\n"
+	case "MGET":
+		res := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			if v, ok := s.get(key); ok {
+				res += bulk(v)
+			} else {
+				res += "$-1\r\n"
+			}
+		}
+		return res
+	case "DEL":
+		_, ok := s.get(args[1])
+		delete(s.data, args[1])
+		delete(s.expires, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "PEXPIRE":
+		if _, ok := s.get(args[1]); !ok {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.Atoi(args[2])
+		s.expires[args[1]] = s.clock.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	case "SCAN":
+		var keys []string
+		for key := range s.data {
+			if _, ok := s.get(key); !ok {
+				continue
+			}
+			if ok, _ := path.Match(args[3], key); ok {
+				keys = append(keys, key)
+			}
+		}
+		res := "*2\r\n" + bulk("0") + fmt.Sprintf("*%d\r\n", len(keys))
+		for _, key := range keys {
+			res += bulk(key)
+		}
+		return res
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestCache(t *testing.T) {
+	srv := newStubServer(t)
+	client := NewClient(srv.ln.Addr().String(), "", 0, time.Second)
+	defer client.Close()
+
+	var c store.Cache[string, interface{}] = New[interface{}](client, "test:", store.StoreNoExpiration, nil)
+
+	_, found, err := c.Get("a", false)
+	if err != nil || found {
+		t.Error("got value while key is not exists", err)
+	}
+
+	err = c.Set("a", 1, store.StoreNoExpiration)
+	if err != nil {
+		t.Error("fail to set", err)
+	}
+	err = c.Set("b", "str", 30*time.Millisecond)
+	if err != nil {
+		t.Error("fail to set", err)
+	}
+	x, found, err := c.Get("a", false)
+	if err != nil || !found || x != 1 {
+		t.Error("bad value", x, err)
+	}
+
+	all, err := c.GetAll()
+	if err != nil || len(all) != 2 {
+		t.Error("must be two elements", all, err)
+	}
+
+	srv.clock.Advance(20 * time.Millisecond)
+	err = c.RefreshExpiration("b")
+	if err != nil {
+		t.Error("fail to refresh", err)
+	}
+	srv.clock.Advance(20 * time.Millisecond)
+	x, found, err = c.Get("b", false)
+	if err != nil || !found || x != "str" {
+		t.Error("refreshed item must exist", x, err)
+	}
+	srv.clock.Advance(30 * time.Millisecond)
+	_, found, _ = c.Get("b", false)
+	if found {
+		t.Error("got value while key should not exists")
+	}
+
+	err = c.Delete("a")
+	if err != nil {
+		t.Error("fail to delete", err)
+	}
+	_, found, _ = c.Get("a", false)
+	if found {
+		t.Error("got value while key is not exists")
+	}
+
+	_, err = client.Do("UNKNOWN")
+	if _, ok := err.(Error); !ok {
+		t.Error("must return server error", err)
+	}
+
+	typed := New[[]string](client, "typed:", store.StoreNoExpiration, store.JSONCodec)
+	err = typed.Set("x", []string{"a", "b"}, store.StoreDefaultDuration)
+	if err != nil {
+		t.Error("fail to set", err)
+	}
+	v, found, err := typed.Get("x", false)
+	if err != nil || !found || len(v) != 2 || v[1] != "b" {
+		t.Error("bad value", v, err)
+	}
+}
+
+func TestMemCache(t *testing.T) {
+	var c store.Cache[string, int] = store.AsCache[string, int](store.NewTyped[string, int](store.StoreNoExpiration, 0))
+
+	err := c.Set("a", 1, store.StoreNoExpiration)
+	if err != nil {
+		t.Error("fail to set", err)
+	}
+	x, found, err := c.Get("a", false)
+	if err != nil || !found || x != 1 {
+		t.Error("bad value", x, err)
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"runtime"
+	"time"
+)
+
+// ShardedStore spreads keys over independent stores, so operations on different keys rarely contend on one lock
+type ShardedStore[K comparable, V any] struct {
+	shards []*TypedStore[K, V]
+	seed   maphash.Seed
+}
+
+// NewSharded creates store with shardCount shards, zero means 4 shards per CPU
+func NewSharded[K comparable, V any](shardCount int, defaultDuration time.Duration,
+	cleanupInterval time.Duration) *ShardedStore[K, V] {
+	return NewShardedContext[K, V](context.Background(), shardCount, defaultDuration, cleanupInterval)
+}
+
+func NewShardedContext[K comparable, V any](ctx context.Context, shardCount int, defaultDuration time.Duration,
+	cleanupInterval time.Duration) *ShardedStore[K, V] {
+	return NewShardedWithClock[K, V](ctx, RealClock, shardCount, defaultDuration, cleanupInterval)
+}
+
+func NewShardedWithClock[K comparable, V any](ctx context.Context, clock Clock, shardCount int,
+	defaultDuration time.Duration, cleanupInterval time.Duration) *ShardedStore[K, V] {
+	if shardCount <= 0 {
+		shardCount = 4 * runtime.GOMAXPROCS(0)
+	}
+	s := &ShardedStore[K, V]{
+		shards: make([]*TypedStore[K, V], shardCount),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewTypedWithClock[K, V](ctx, clock, defaultDuration, cleanupInterval)
+	}
+	return s
+}
+
+func (s *ShardedStore[K, V]) shard(key K) *TypedStore[K, V] {
+	return s.shards[maphash.Comparable(s.seed, key)%uint64(len(s.shards))]
+}
+
+func (s *ShardedStore[K, V]) Close() {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+}
+
+func (s *ShardedStore[K, V]) OnEvicted(f EvictionFunc[K, V]) {
+	for _, shard := range s.shards {
+		shard.OnEvicted(f)
+	}
+}
+
+func (s *ShardedStore[K, V]) OnExpired(f func(key K, value V)) {
+	for _, shard := range s.shards {
+		shard.OnExpired(f)
+	}
+}
+
+var ErrCapacityTooSmall = errors.New("store: capacity is smaller than count of shards")
+
+// SetCapacity splits limits between shards, so their sum equals the limit, newPolicy is called once per shard.
+// Every shard enforces its own part, so a hot shard evicts while the whole store may be under the limit,
+// and an item costing more than a part of maxCost is not stored.
+// Limits smaller than count of shards can not be split and give ErrCapacityTooSmall.
+func (s *ShardedStore[K, V]) SetCapacity(maxEntries int, maxCost int64, newPolicy func() EvictionPolicy[K]) error {
+	n := len(s.shards)
+	if (maxEntries > 0 && maxEntries < n) || (maxCost > 0 && maxCost < int64(n)) {
+		return ErrCapacityTooSmall
+	}
+	for i, shard := range s.shards {
+		var policy EvictionPolicy[K]
+		if newPolicy != nil {
+			policy = newPolicy()
+		}
+		entries, cost := maxEntries/n, maxCost/int64(n)
+		if i < maxEntries%n {
+			entries++
+		}
+		if int64(i) < maxCost%int64(n) {
+			cost++
+		}
+		shard.SetCapacity(entries, cost, policy)
+	}
+	return nil
+}
+
+func (s *ShardedStore[K, V]) SetCostFunc(f func(key K, value V) int64) {
+	for _, shard := range s.shards {
+		shard.SetCostFunc(f)
+	}
+}
+
+func (s *ShardedStore[K, V]) SetLock(key K, value V, duration time.Duration) {
+	s.shard(key).SetLock(key, value, duration)
+}
+
+func (s *ShardedStore[K, V]) GetLock(key K, refreshExpiration bool) (V, bool) {
+	return s.shard(key).GetLock(key, refreshExpiration)
+}
+
+func (s *ShardedStore[K, V]) GetAllLock() []*TypedListRow[K, V] {
+	var all []*TypedListRow[K, V]
+	for _, shard := range s.shards {
+		all = append(all, shard.GetAllLock()...)
+	}
+	return all
+}
+
+func (s *ShardedStore[K, V]) RefreshExpirationLock(key K) {
+	s.shard(key).RefreshExpirationLock(key)
+}
+
+func (s *ShardedStore[K, V]) DeleteLock(key K) {
+	s.shard(key).DeleteLock(key)
+}
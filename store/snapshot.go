@@ -0,0 +1,154 @@
+package store
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// Codec serialises store snapshots
+type Codec interface {
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+type gobCodec struct{}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder {
+	return gob.NewEncoder(w)
+}
+
+func (gobCodec) NewDecoder(r io.Reader) Decoder {
+	return gob.NewDecoder(r)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func (jsonCodec) NewDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
+
+var (
+	// GobCodec is used by default. Concrete types stored in interface values must be registered with gob.Register
+	GobCodec Codec = gobCodec{}
+	// JSONCodec decodes interface values as JSON does: numbers become float64, objects - maps
+	JSONCodec Codec = jsonCodec{}
+)
+
+type snapshotItem[K comparable, V any] struct {
+	Key        K
+	Value      V
+	Duration   time.Duration
+	Expiration int64
+	Tags       []string
+}
+
+func (s *TypedStore[K, V]) SetCodec(c Codec) {
+	s.Lock()
+	s.codec = c
+	s.Unlock()
+}
+
+// SaveTo writes all not expired items with their expiration
+func (s *TypedStore[K, V]) SaveTo(w io.Writer) error {
+	s.mu.RLock()
+	codec := s.codec
+	now := s.clock.Now().UnixNano()
+	items := make([]snapshotItem[K, V], 0, len(s.store))
+	for key, item := range s.store {
+		if item.expired(now) {
+			continue
+		}
+		items = append(items, snapshotItem[K, V]{
+			Key:        key,
+			Value:      item.value,
+			Duration:   item.duration,
+			Expiration: item.expiration,
+			Tags:       item.tags,
+		})
+	}
+	s.mu.RUnlock()
+
+	if codec == nil {
+		codec = GobCodec
+	}
+	return codec.NewEncoder(w).Encode(items)
+}
+
+// LoadFrom adds items from snapshot, items expired since saving are skipped
+func (s *TypedStore[K, V]) LoadFrom(r io.Reader) error {
+	s.mu.RLock()
+	codec := s.codec
+	s.mu.RUnlock()
+	if codec == nil {
+		codec = GobCodec
+	}
+
+	var items []snapshotItem[K, V]
+	err := codec.NewDecoder(r).Decode(&items)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	now := s.clock.Now().UnixNano()
+	for _, item := range items {
+		if (item.Expiration != 0) && (now >= item.Expiration) {
+			continue
+		}
+		s.set(item.Key, item.Value, item.Duration, item.Expiration, item.Tags, now)
+	}
+	return nil
+}
+
+// SaveToFile writes snapshot to temporary file and then renames it, so the file is never left half-written
+func (s *TypedStore[K, V]) SaveToFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = s.SaveTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// LoadFromFile does nothing if file is not exists
+func (s *TypedStore[K, V]) LoadFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	return s.LoadFrom(f)
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync/atomic"
+)
+
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Sets        uint64
+	Deletes     uint64
+	Expirations uint64
+	Evictions   uint64
+	Items       int
+	ApproxBytes int64
+}
+
+type StatsSource interface {
+	Stats() Stats
+}
+
+type counters struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	sets        atomic.Uint64
+	deletes     atomic.Uint64
+	expirations atomic.Uint64
+	evictions   atomic.Uint64
+}
+
+func (c *counters) lookup(found bool) {
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+func (c *counters) removed(reason EvictionReason) {
+	switch reason {
+	case EvictionDeleted:
+		c.deletes.Add(1)
+	case EvictionExpired:
+		c.expirations.Add(1)
+	case EvictionCapacity:
+		c.evictions.Add(1)
+	}
+}
+
+// Stats walks all items to estimate memory, so it is not for hot paths
+func (s *TypedStore[K, V]) Stats() Stats {
+	st := Stats{
+		Hits:        s.stats.hits.Load(),
+		Misses:      s.stats.misses.Load(),
+		Sets:        s.stats.sets.Load(),
+		Deletes:     s.stats.deletes.Load(),
+		Expirations: s.stats.expirations.Load(),
+		Evictions:   s.stats.evictions.Load(),
+	}
+
+	s.mu.RLock()
+	st.Items = len(s.store)
+	for key, item := range s.store {
+		st.ApproxBytes += int64(reflect.TypeOf(*item).Size()) + approxSize(reflect.ValueOf(key), 0) +
+			approxSize(reflect.ValueOf(&item.value).Elem(), 0)
+	}
+	s.mu.RUnlock()
+
+	return st
+}
+
+func (s *ShardedStore[K, V]) Stats() Stats {
+	st := Stats{}
+	for _, shard := range s.shards {
+		ss := shard.Stats()
+		st.Hits += ss.Hits
+		st.Misses += ss.Misses
+		st.Sets += ss.Sets
+		st.Deletes += ss.Deletes
+		st.Expirations += ss.Expirations
+		st.Evictions += ss.Evictions
+		st.Items += ss.Items
+		st.ApproxBytes += ss.ApproxBytes
+	}
+	return st
+}
+
+const approxSizeMaxDepth = 4
+
+// approxSize estimates memory of the value with referenced data, shared data is counted every time
+func approxSize(v reflect.Value, depth int) int64 {
+	if !v.IsValid() {
+		return 0
+	}
+	size := int64(v.Type().Size())
+	if depth >= approxSizeMaxDepth {
+		return size
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		size += int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		size += int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += approxSize(v.Index(i), depth+1) - int64(v.Type().Elem().Size())
+		}
+	case reflect.Array:
+		size = 0
+		for i := 0; i < v.Len(); i++ {
+			size += approxSize(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			size += approxSize(iter.Key(), depth+1) + approxSize(iter.Value(), depth+1)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			size += approxSize(v.Elem(), depth+1)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			size += approxSize(v.Elem(), depth+1)
+		}
+	case reflect.Struct:
+		size = 0
+		for i := 0; i < v.NumField(); i++ {
+			size += approxSize(v.Field(i), depth+1)
+		}
+	}
+
+	return size
+}
+
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value func(st Stats) interface{}
+}
+
+var metrics = []metric{
+	{"lily_store_hits_total", "counter", "Count of found lookups.", func(st Stats) interface{} { return st.Hits }},
+	{"lily_store_misses_total", "counter", "Count of not found lookups.", func(st Stats) interface{} { return st.Misses }},
+	{"lily_store_sets_total", "counter", "Count of set items.", func(st Stats) interface{} { return st.Sets }},
+	{"lily_store_deletes_total", "counter", "Count of deleted items.", func(st Stats) interface{} { return st.Deletes }},
+	{"lily_store_expirations_total", "counter", "Count of expired items.", func(st Stats) interface{} { return st.Expirations }},
+	{"lily_store_evictions_total", "counter", "Count of items evicted by capacity.", func(st Stats) interface{} { return st.Evictions }},
+	{"lily_store_items", "gauge", "Current count of items.", func(st Stats) interface{} { return st.Items }},
+	{"lily_store_approx_bytes", "gauge", "Approximate memory of items.", func(st Stats) interface{} { return st.ApproxBytes }},
+}
+
+// WritePrometheus writes stats of named stores in prometheus text format
+func WritePrometheus(w io.Writer, stores map[string]StatsSource) error {
+	names := make([]string, 0, len(stores))
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := make([]Stats, len(names))
+	for i, name := range names {
+		stats[i] = stores[name].Stats()
+	}
+
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		if err != nil {
+			return err
+		}
+		for i, name := range names {
+			_, err = fmt.Fprintf(w, "%s{store=%q} %v\n", m.name, name, m.value(stats[i]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func MetricsHandler(stores map[string]StatsSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, stores)
+	})
+}
@@ -1,142 +1,446 @@
 package store
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type Store struct {
+type TypedStore[K comparable, V any] struct {
 	mu              sync.RWMutex
-	store           map[string]*Item
+	store           map[K]*TypedItem[V]
 	defaultDuration time.Duration
+	clock           Clock
+	stop            chan struct{}
+	stopOnce        sync.Once
+	cleanerDone     chan struct{}
+	onEvicted       []EvictionFunc[K, V]
+	evicted         []eviction[K, V]
+	maxEntries      int
+	maxCost         int64
+	cost            int64
+	costFunc        func(key K, value V) int64
+	policy          EvictionPolicy[K]
+	codec           Codec
+	calls           map[K]*computeCall[V]
+	stats           counters
+	tagIndex        map[string]map[K]struct{}
+	subMu           sync.Mutex
+	subs            []*Subscription[K, V]
+	subCount        atomic.Int32
 }
 
-type Item struct {
-	value      interface{}
+type TypedItem[V any] struct {
+	value      V
 	duration   time.Duration
 	expiration int64
+	cost       int64
+	tags       []string
 }
 
-type ListRow struct {
-	Key   string
-	Value interface{}
+func (i *TypedItem[V]) expired(now int64) bool {
+	return (i.expiration != 0) && (now >= i.expiration)
 }
 
+type TypedListRow[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type EvictionReason int
+
+const (
+	EvictionExpired EvictionReason = iota + 1
+	EvictionDeleted
+	EvictionReplaced
+	EvictionCapacity
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionExpired:
+		return "expired"
+	case EvictionDeleted:
+		return "deleted"
+	case EvictionReplaced:
+		return "replaced"
+	case EvictionCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+// EvictionFunc is called outside the store lock, so it may use the store freely
+type EvictionFunc[K comparable, V any] func(key K, value V, reason EvictionReason)
+
+type eviction[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictionReason
+}
+
+// Store is the untyped store, kept for compatibility with existing callers
+type Store = TypedStore[string, interface{}]
+
+type Item = TypedItem[interface{}]
+
+type ListRow = TypedListRow[string, interface{}]
+
 const (
 	StoreDefaultDuration time.Duration = -1
 	StoreNoExpiration    time.Duration = 0
 )
 
 func New(defaultDuration time.Duration, cleanupInterval time.Duration) *Store {
+	return NewTyped[string, interface{}](defaultDuration, cleanupInterval)
+}
+
+// NewContext creates store, which cleaner stops when ctx is done or Close is called
+func NewContext(ctx context.Context, defaultDuration time.Duration, cleanupInterval time.Duration) *Store {
+	return NewTypedContext[string, interface{}](ctx, defaultDuration, cleanupInterval)
+}
+
+func NewTyped[K comparable, V any](defaultDuration time.Duration, cleanupInterval time.Duration) *TypedStore[K, V] {
+	return NewTypedContext[K, V](context.Background(), defaultDuration, cleanupInterval)
+}
+
+func NewTypedContext[K comparable, V any](ctx context.Context, defaultDuration time.Duration,
+	cleanupInterval time.Duration) *TypedStore[K, V] {
+	return NewTypedWithClock[K, V](ctx, RealClock, defaultDuration, cleanupInterval)
+}
+
+// NewWithClock creates store, which takes time from clock, it is mostly for tests with FakeClock
+func NewWithClock(ctx context.Context, clock Clock, defaultDuration time.Duration,
+	cleanupInterval time.Duration) *Store {
+	return NewTypedWithClock[string, interface{}](ctx, clock, defaultDuration, cleanupInterval)
+}
+
+func NewTypedWithClock[K comparable, V any](ctx context.Context, clock Clock, defaultDuration time.Duration,
+	cleanupInterval time.Duration) *TypedStore[K, V] {
 	if defaultDuration == StoreDefaultDuration {
 		defaultDuration = StoreNoExpiration
 	}
-	s := &Store{
-		store:           make(map[string]*Item),
+	s := &TypedStore[K, V]{
+		store:           make(map[K]*TypedItem[V]),
 		defaultDuration: defaultDuration,
+		clock:           clock,
+		stop:            make(chan struct{}),
 	}
 	if cleanupInterval > 0 {
-		go cleaner(s, cleanupInterval)
+		s.cleanerDone = make(chan struct{})
+		go cleaner(ctx, s, s.clock.NewTicker(cleanupInterval))
 	}
 	return s
 }
 
-func cleaner(s *Store, interval time.Duration) {
-	tick := time.Tick(interval)
-	for now := range tick {
-		unixNanoNow := now.UnixNano()
-		s.mu.Lock()
-		for key, item := range s.store {
-			if (item.expiration != 0) && (unixNanoNow >= item.expiration) {
-				delete(s.store, key)
+func cleaner[K comparable, V any](ctx context.Context, s *TypedStore[K, V], ticker Ticker) {
+	defer close(s.cleanerDone)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-ticker.C():
+			unixNanoNow := s.clock.Now().UnixNano()
+			s.Lock()
+			for key, item := range s.store {
+				if item.expired(unixNanoNow) {
+					s.remove(key, item, EvictionExpired)
+				}
 			}
+			s.Unlock()
 		}
-		s.mu.Unlock()
 	}
 }
 
-func (s *Store) Lock() {
+// Close stops the cleaner and waits for it to exit. Store stays usable, expired items are still hidden on read
+func (s *TypedStore[K, V]) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	if s.cleanerDone != nil {
+		<-s.cleanerDone
+	}
+}
+
+// OnEvicted registers callback, called for every item removed from the store
+func (s *TypedStore[K, V]) OnEvicted(f EvictionFunc[K, V]) {
+	s.mu.Lock()
+	s.onEvicted = append(s.onEvicted, f)
+	s.mu.Unlock()
+}
+
+// OnExpired registers callback, called only for expired items
+func (s *TypedStore[K, V]) OnExpired(f func(key K, value V)) {
+	s.OnEvicted(func(key K, value V, reason EvictionReason) {
+		if reason == EvictionExpired {
+			f(key, value)
+		}
+	})
+}
+
+func (s *TypedStore[K, V]) Lock() {
 	s.mu.Lock()
 }
 
-func (s *Store) Unlock() {
+// Unlock releases the lock and then calls eviction callbacks for items removed while it was held
+func (s *TypedStore[K, V]) Unlock() {
+	evicted, callbacks := s.evicted, s.onEvicted
+	s.evicted = nil
 	s.mu.Unlock()
+	for _, e := range evicted {
+		for _, f := range callbacks {
+			f(e.key, e.value, e.reason)
+		}
+	}
 }
 
-func (s *Store) Set(key string, value interface{}, duration time.Duration) {
-	if duration == StoreDefaultDuration {
-		duration = s.defaultDuration
+// SetCapacity limits the store by count of items and (or) total cost of items, zero means no limit.
+// Policy chooses items to drop on overflow, LRU is used when it is nil.
+// Item costing more than maxCost is not stored, it is evicted with EvictionCapacity at once.
+func (s *TypedStore[K, V]) SetCapacity(maxEntries int, maxCost int64, policy EvictionPolicy[K]) {
+	s.Lock()
+	defer s.Unlock()
+	s.maxEntries = maxEntries
+	s.maxCost = maxCost
+	if maxEntries <= 0 && maxCost <= 0 {
+		s.policy = nil
+		return
+	}
+	if policy == nil {
+		policy = NewLRU[K]()
+	}
+	s.policy = policy
+	for key := range s.store {
+		s.policy.Added(key)
 	}
-	var expiration int64 = 0
-	if duration > 0 {
-		expiration = time.Now().Add(duration).UnixNano()
+	s.enforceCapacity(0, 0)
+}
+
+// SetCostFunc sets the cost of item for the max-cost limit, every item costs 1 by default
+func (s *TypedStore[K, V]) SetCostFunc(f func(key K, value V) int64) {
+	s.Lock()
+	s.costFunc = f
+	s.Unlock()
+}
+
+// remove must be called under Lock
+func (s *TypedStore[K, V]) remove(key K, item *TypedItem[V], reason EvictionReason) {
+	delete(s.store, key)
+	s.cost -= item.cost
+	s.untag(key, item)
+	if s.policy != nil {
+		s.policy.Removed(key)
+	}
+	s.evict(key, item, reason)
+}
+
+// enforceCapacity evicts items until the store, extended by extra entries and cost, fits into limits
+func (s *TypedStore[K, V]) enforceCapacity(extraEntries int, extraCost int64) {
+	if s.policy == nil {
+		return
 	}
-	s.store[key] = &Item{
+	now := s.clock.Now().UnixNano()
+	for ((s.maxEntries > 0) && (len(s.store)+extraEntries > s.maxEntries)) ||
+		((s.maxCost > 0) && (s.cost+extraCost > s.maxCost)) {
+		key, ok := s.policy.Victim()
+		if !ok {
+			return
+		}
+		item, found := s.store[key]
+		if !found {
+			s.policy.Removed(key)
+			continue
+		}
+		if item.expired(now) {
+			s.remove(key, item, EvictionExpired)
+		} else {
+			s.remove(key, item, EvictionCapacity)
+		}
+	}
+}
+
+func (s *TypedStore[K, V]) accessed(key K) {
+	if s.policy != nil {
+		s.policy.Accessed(key)
+	}
+}
+
+func (s *TypedStore[K, V]) evict(key K, item *TypedItem[V], reason EvictionReason) {
+	s.stats.removed(reason)
+	if reason != EvictionReplaced {
+		s.publish(eventOf(reason), key, item.value)
+	}
+	if len(s.onEvicted) > 0 {
+		s.evicted = append(s.evicted, eviction[K, V]{key: key, value: item.value, reason: reason})
+	}
+}
+
+func (s *TypedStore[K, V]) Set(key K, value V, duration time.Duration) {
+	s.SetWithTags(key, value, duration)
+}
+
+func (s *TypedStore[K, V]) set(key K, value V, duration time.Duration, expiration int64, tags []string, now int64) {
+	s.stats.sets.Add(1)
+	var cost int64 = 1
+	if s.costFunc != nil {
+		cost = s.costFunc(key, value)
+	}
+	if s.policy != nil && s.maxCost > 0 && cost > s.maxCost {
+		// item never fits, so only it is rejected instead of emptying the whole store
+		if old, found := s.store[key]; found {
+			s.remove(key, old, EvictionReplaced)
+		}
+		s.evict(key, &TypedItem[V]{value: value, cost: cost, tags: tags}, EvictionCapacity)
+		return
+	}
+	old, replace := s.store[key]
+	if replace {
+		s.cost -= old.cost
+		s.untag(key, old)
+		if old.expired(now) {
+			s.evict(key, old, EvictionExpired)
+		} else {
+			s.evict(key, old, EvictionReplaced)
+		}
+	} else {
+		// make room before insert, otherwise the new item may become the victim itself
+		s.enforceCapacity(1, cost)
+	}
+	s.store[key] = &TypedItem[V]{
 		value:      value,
 		duration:   duration,
 		expiration: expiration,
+		cost:       cost,
+		tags:       tags,
+	}
+	s.cost += cost
+	s.tag(key, tags)
+	s.publish(EventSet, key, value)
+	if s.policy != nil {
+		if replace {
+			s.policy.Accessed(key)
+		} else {
+			s.policy.Added(key)
+		}
+		s.enforceCapacity(0, 0)
 	}
 }
 
-func (s *Store) SetLock(key string, value interface{}, duration time.Duration) {
-	s.mu.Lock()
+func (s *TypedStore[K, V]) SetLock(key K, value V, duration time.Duration) {
+	s.Lock()
 	s.Set(key, value, duration)
-	s.mu.Unlock()
+	s.Unlock()
 }
 
-func (s *Store) Get(key string, refreshExpiration bool) (interface{}, bool) {
+// Get must be called under Lock, expired item is deleted on the spot
+func (s *TypedStore[K, V]) Get(key K, refreshExpiration bool) (V, bool) {
+	now := s.clock.Now()
 	item, found := s.store[key]
+	if found && item.expired(now.UnixNano()) {
+		s.remove(key, item, EvictionExpired)
+		found = false
+	}
+	s.stats.lookup(found)
 	if found {
-		if refreshExpiration {
-			item.expiration = time.Now().Add(item.duration).UnixNano()
+		if refreshExpiration && (item.duration > 0) {
+			item.expiration = now.Add(item.duration).UnixNano()
 		}
+		s.accessed(key)
 		return item.value, true
 	} else {
-		return nil, false
+		var zero V
+		return zero, false
 	}
 }
 
-func (s *Store) GetLock(key string, refreshExpiration bool) (interface{}, bool) {
-	s.mu.RLock()
+func (s *TypedStore[K, V]) GetLock(key K, refreshExpiration bool) (V, bool) {
+	if !refreshExpiration {
+		s.mu.RLock()
+		if s.policy == nil {
+			value, found := s.peek(key)
+			s.mu.RUnlock()
+			return value, found
+		}
+		s.mu.RUnlock()
+	}
+	// refresh and eviction policy modify the store, so exclusive lock is required
+	s.Lock()
 	value, found := s.Get(key, refreshExpiration)
-	s.mu.RUnlock()
+	s.Unlock()
 	return value, found
 }
 
-func (s *Store) GetAll() []*ListRow {
-	all := make([]*ListRow, 0, len(s.store))
+// peek is safe under read lock: expired item is reported as absent, but kept for the cleaner
+func (s *TypedStore[K, V]) peek(key K) (V, bool) {
+	item, found := s.store[key]
+	if !found || item.expired(s.clock.Now().UnixNano()) {
+		s.stats.lookup(false)
+		var zero V
+		return zero, false
+	}
+	s.stats.lookup(true)
+	return item.value, true
+}
+
+func (s *TypedStore[K, V]) GetAll() []*TypedListRow[K, V] {
+	now := s.clock.Now().UnixNano()
+	all := make([]*TypedListRow[K, V], 0, len(s.store))
 	for key, item := range s.store {
-		all = append(all, &ListRow{Key: key, Value: item.value})
+		if item.expired(now) {
+			continue
+		}
+		all = append(all, &TypedListRow[K, V]{Key: key, Value: item.value})
 	}
 	return all
 }
 
-func (s *Store) GetAllLock() []*ListRow {
+func (s *TypedStore[K, V]) GetAllLock() []*TypedListRow[K, V] {
 	s.mu.RLock()
 	all := s.GetAll()
 	s.mu.RUnlock()
 	return all
 }
 
-func (s *Store) RefreshExpiration(key string) {
+func (s *TypedStore[K, V]) RefreshExpiration(key K) {
+	now := s.clock.Now()
 	item, found := s.store[key]
-	if found && (item.duration > 0) {
-		item.expiration = time.Now().Add(item.duration).UnixNano()
+	if !found {
+		return
 	}
+	if item.expired(now.UnixNano()) {
+		s.remove(key, item, EvictionExpired)
+		return
+	}
+	if item.duration > 0 {
+		item.expiration = now.Add(item.duration).UnixNano()
+	}
+	s.accessed(key)
 }
 
-func (s *Store) RefreshExpirationLock(key string) {
-	s.mu.Lock()
+func (s *TypedStore[K, V]) RefreshExpirationLock(key K) {
+	s.Lock()
 	s.RefreshExpiration(key)
-	s.mu.Unlock()
+	s.Unlock()
 }
 
-func (s *Store) Delete(key string) {
-	delete(s.store, key)
+func (s *TypedStore[K, V]) Delete(key K) {
+	item, found := s.store[key]
+	if !found {
+		return
+	}
+	if item.expired(s.clock.Now().UnixNano()) {
+		s.remove(key, item, EvictionExpired)
+	} else {
+		s.remove(key, item, EvictionDeleted)
+	}
 }
 
-func (s *Store) DeleteLock(key string) {
-	s.mu.Lock()
+func (s *TypedStore[K, V]) DeleteLock(key K) {
+	s.Lock()
 	s.Delete(key)
-	s.mu.Unlock()
+	s.Unlock()
 }
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type EventType int
+
+const (
+	EventSet EventType = iota + 1
+	EventDelete
+	EventExpire
+	EventEvict
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+type Event[K comparable, V any] struct {
+	Type  EventType
+	Key   K
+	Value V
+}
+
+// DropPolicy tells what to do when subscriber's buffer is full, the store never waits for subscribers
+type DropPolicy int
+
+const (
+	DropNewest DropPolicy = iota
+	DropOldest
+)
+
+type Subscription[K comparable, V any] struct {
+	C <-chan Event[K, V]
+
+	ch      chan Event[K, V]
+	prefix  string
+	policy  DropPolicy
+	dropped atomic.Uint64
+
+	mu     sync.Mutex
+	closed bool
+	detach []func()
+}
+
+func newSubscription[K comparable, V any](prefix string, buffer int, policy DropPolicy) *Subscription[K, V] {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan Event[K, V], buffer)
+	return &Subscription[K, V]{
+		C:      ch,
+		ch:     ch,
+		prefix: prefix,
+		policy: policy,
+	}
+}
+
+// Dropped returns count of events lost because of full buffer
+func (sub *Subscription[K, V]) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+// Close unsubscribes and closes C
+func (sub *Subscription[K, V]) Close() {
+	sub.mu.Lock()
+	if sub.closed {
+		sub.mu.Unlock()
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	detach := sub.detach
+	sub.detach = nil
+	sub.mu.Unlock()
+
+	for _, f := range detach {
+		f()
+	}
+}
+
+func (sub *Subscription[K, V]) send(e Event[K, V]) {
+	if !keyHasPrefix(e.Key, sub.prefix) {
+		return
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+
+	select {
+	case sub.ch <- e:
+		return
+	default:
+	}
+	if sub.policy == DropOldest {
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+	sub.dropped.Add(1)
+}
+
+// Subscribe returns stream of changes of items with the key prefix, empty prefix means all items
+func (s *TypedStore[K, V]) Subscribe(prefix string, buffer int, policy DropPolicy) *Subscription[K, V] {
+	sub := newSubscription[K, V](prefix, buffer, policy)
+	s.attach(sub)
+	return sub
+}
+
+// SubscribeFunc calls fn for every event in separate goroutine until subscription is closed
+func (s *TypedStore[K, V]) SubscribeFunc(prefix string, buffer int, policy DropPolicy,
+	fn func(e Event[K, V])) *Subscription[K, V] {
+	sub := s.Subscribe(prefix, buffer, policy)
+	go consume(sub, fn)
+	return sub
+}
+
+func consume[K comparable, V any](sub *Subscription[K, V], fn func(e Event[K, V])) {
+	for e := range sub.C {
+		fn(e)
+	}
+}
+
+func (s *TypedStore[K, V]) attach(sub *Subscription[K, V]) {
+	s.subMu.Lock()
+	s.subs = append(s.subs, sub)
+	s.subCount.Add(1)
+	s.subMu.Unlock()
+
+	sub.mu.Lock()
+	sub.detach = append(sub.detach, func() {
+		s.subMu.Lock()
+		for i, x := range s.subs {
+			if x == sub {
+				s.subs = append(s.subs[:i], s.subs[i+1:]...)
+				s.subCount.Add(-1)
+				break
+			}
+		}
+		s.subMu.Unlock()
+	})
+	sub.mu.Unlock()
+}
+
+// publish must be called under Lock
+func (s *TypedStore[K, V]) publish(t EventType, key K, value V) {
+	if s.subCount.Load() == 0 {
+		return
+	}
+	e := Event[K, V]{Type: t, Key: key, Value: value}
+	s.subMu.Lock()
+	for _, sub := range s.subs {
+		sub.send(e)
+	}
+	s.subMu.Unlock()
+}
+
+func eventOf(reason EvictionReason) EventType {
+	switch reason {
+	case EvictionDeleted:
+		return EventDelete
+	case EvictionExpired:
+		return EventExpire
+	case EvictionCapacity:
+		return EventEvict
+	}
+	return 0
+}
+
+func (s *ShardedStore[K, V]) Subscribe(prefix string, buffer int, policy DropPolicy) *Subscription[K, V] {
+	sub := newSubscription[K, V](prefix, buffer, policy)
+	for _, shard := range s.shards {
+		shard.attach(sub)
+	}
+	return sub
+}
+
+func (s *ShardedStore[K, V]) SubscribeFunc(prefix string, buffer int, policy DropPolicy,
+	fn func(e Event[K, V])) *Subscription[K, V] {
+	sub := s.Subscribe(prefix, buffer, policy)
+	go consume(sub, fn)
+	return sub
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"time"
+)
+
+// tag must be called under Lock
+func (s *TypedStore[K, V]) tag(key K, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if s.tagIndex == nil {
+		s.tagIndex = make(map[string]map[K]struct{})
+	}
+	for _, tag := range tags {
+		keys := s.tagIndex[tag]
+		if keys == nil {
+			keys = make(map[K]struct{})
+			s.tagIndex[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// untag must be called under Lock
+func (s *TypedStore[K, V]) untag(key K, item *TypedItem[V]) {
+	for _, tag := range item.tags {
+		keys := s.tagIndex[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(s.tagIndex, tag)
+		}
+	}
+}
+
+// SetWithTags sets item, which can be removed later by any of tags with InvalidateTag
+func (s *TypedStore[K, V]) SetWithTags(key K, value V, duration time.Duration, tags ...string) {
+	if duration == StoreDefaultDuration {
+		duration = s.defaultDuration
+	}
+	now := s.clock.Now()
+	var expiration int64 = 0
+	if duration > 0 {
+		expiration = now.Add(duration).UnixNano()
+	}
+	s.set(key, value, duration, expiration, append([]string(nil), tags...), now.UnixNano())
+}
+
+func (s *TypedStore[K, V]) SetWithTagsLock(key K, value V, duration time.Duration, tags ...string) {
+	s.Lock()
+	s.SetWithTags(key, value, duration, tags...)
+	s.Unlock()
+}
+
+// InvalidateTag deletes all items with the tag and returns their count
+func (s *TypedStore[K, V]) InvalidateTag(tag string) int {
+	keys := s.tagIndex[tag]
+	cnt := len(keys)
+	for key := range keys {
+		s.Delete(key)
+	}
+	return cnt
+}
+
+func (s *TypedStore[K, V]) InvalidateTagLock(tag string) int {
+	s.Lock()
+	cnt := s.InvalidateTag(tag)
+	s.Unlock()
+	return cnt
+}
+
+func (s *ShardedStore[K, V]) SetWithTagsLock(key K, value V, duration time.Duration, tags ...string) {
+	s.shard(key).SetWithTagsLock(key, value, duration, tags...)
+}
+
+// InvalidateTagLock locks shards one by one, so it is atomic only within a shard
+func (s *ShardedStore[K, V]) InvalidateTagLock(tag string) int {
+	cnt := 0
+	for _, shard := range s.shards {
+		cnt += shard.InvalidateTagLock(tag)
+	}
+	return cnt
+}
@@ -1,6 +1,15 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -56,20 +65,21 @@ func TestStore(t *testing.T) {
 }
 
 func TestStoreExpiration(t *testing.T) {
-	st := New(StoreDefaultDuration, 10*time.Millisecond)
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreDefaultDuration, 10*time.Millisecond)
 	if st == nil {
 		t.Error("Fail to create store")
 	}
 	if st.defaultDuration != StoreNoExpiration {
 		t.Error("default duration-value must be StoreNoExpiration")
 	}
-	st = New(20*time.Millisecond, 10*time.Millisecond)
+	st = NewWithClock(context.Background(), clock, 20*time.Millisecond, 10*time.Millisecond)
 	if st == nil {
 		t.Error("Fail to create store")
 	}
 
 	st.SetLock("a", true, 20*time.Millisecond)
-	<-time.After(15 * time.Millisecond)
+	clock.Advance(15 * time.Millisecond)
 	x, ok := st.GetLock("a", false)
 	if !ok {
 		t.Error("not found key that is exists")
@@ -78,14 +88,14 @@ func TestStoreExpiration(t *testing.T) {
 	if a != true {
 		t.Error("bad value")
 	}
-	<-time.After(10 * time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
 	x, ok = st.GetLock("a", false)
 	if ok {
 		t.Error("got value while key should not exists")
 	}
 
 	st.SetLock("a", true, StoreDefaultDuration)
-	<-time.After(15 * time.Millisecond)
+	clock.Advance(15 * time.Millisecond)
 	x, ok = st.GetLock("a", false)
 	if !ok {
 		t.Error("not found key that is exists")
@@ -95,7 +105,7 @@ func TestStoreExpiration(t *testing.T) {
 		t.Error("bad value")
 	}
 	st.RefreshExpirationLock("a")
-	<-time.After(15 * time.Millisecond)
+	clock.Advance(15 * time.Millisecond)
 	x, ok = st.GetLock("a", false)
 	if !ok {
 		t.Error("not found key that is exists")
@@ -104,14 +114,14 @@ func TestStoreExpiration(t *testing.T) {
 	if a != true {
 		t.Error("bad value")
 	}
-	<-time.After(15 * time.Millisecond)
+	clock.Advance(15 * time.Millisecond)
 	x, ok = st.GetLock("a", false)
 	if ok {
 		t.Error("got value while key should not exists")
 	}
 
 	st.SetLock("a", true, StoreNoExpiration)
-	<-time.After(25 * time.Millisecond)
+	clock.Advance(25 * time.Millisecond)
 	x, ok = st.GetLock("a", false)
 	if !ok {
 		t.Error("not found key that should exists")
@@ -121,3 +131,862 @@ func TestStoreExpiration(t *testing.T) {
 		t.Error("bad value")
 	}
 }
+
+func TestTypedStore(t *testing.T) {
+	st := NewTyped[int, string](StoreNoExpiration, 0)
+	if st == nil {
+		t.Error("Fail to create store")
+	}
+
+	x, ok := st.GetLock(1, false)
+	if ok {
+		t.Error("got value while key is not exists:", x)
+	}
+	if x != "" {
+		t.Error("must be zero value for not existing key")
+	}
+
+	st.SetLock(1, "a", StoreNoExpiration)
+	st.SetLock(2, "b", StoreDefaultDuration)
+	x, ok = st.GetLock(1, false)
+	if !ok {
+		t.Error("not found key that is exists")
+	}
+	if x != "a" {
+		t.Error("bad value")
+	}
+	all := st.GetAllLock()
+	if len(all) != 2 {
+		t.Error("must be two elements")
+	}
+	for _, row := range all {
+		if (row.Key == 1 && row.Value != "a") || (row.Key == 2 && row.Value != "b") {
+			t.Error("bad row", row.Key, row.Value)
+		}
+	}
+	st.DeleteLock(1)
+	x, ok = st.GetLock(1, false)
+	if ok {
+		t.Error("got value while key is not exists:", x)
+	}
+}
+
+func TestStoreLazyExpiration(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+
+	st.SetLock("a", true, 5*time.Millisecond)
+	st.SetLock("b", true, StoreNoExpiration)
+	clock.Advance(10 * time.Millisecond)
+
+	x, ok := st.GetLock("a", false)
+	if ok {
+		t.Error("got expired value:", x)
+	}
+	x, ok = st.GetLock("a", true)
+	if ok {
+		t.Error("got expired value:", x)
+	}
+	if _, ok = st.store["a"]; ok {
+		t.Error("expired item must be deleted on get")
+	}
+
+	st.SetLock("a", true, 5*time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+	all := st.GetAllLock()
+	if len(all) != 1 || all[0].Key != "b" {
+		t.Error("expired item must not be listed")
+	}
+	st.RefreshExpirationLock("a")
+	x, ok = st.GetLock("a", false)
+	if ok {
+		t.Error("expired item must not be revived by refresh:", x)
+	}
+
+	st.SetLock("a", true, 5*time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+	st.Lock()
+	x, ok = st.Get("a", false)
+	st.Unlock()
+	if ok {
+		t.Error("got expired value:", x)
+	}
+
+	x, ok = st.GetLock("b", true)
+	if !ok {
+		t.Error("refresh must not expire item without expiration")
+	}
+
+	st.SetLock("c", true, 5*time.Millisecond)
+	clock.Advance(5*time.Millisecond - time.Nanosecond)
+	x, ok = st.GetLock("c", false)
+	if !ok {
+		t.Error("item must be visible until its expiration")
+	}
+	clock.Advance(time.Nanosecond)
+	x, ok = st.GetLock("c", false)
+	if ok {
+		t.Error("item must be invisible at its expiration:", x)
+	}
+}
+
+func TestStoreClose(t *testing.T) {
+	stopped := func(st *Store) bool {
+		select {
+		case <-st.cleanerDone:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	stores := make([]*Store, 0, 10)
+	for i := 0; i < 10; i++ {
+		stores = append(stores, New(StoreNoExpiration, time.Millisecond))
+	}
+	for _, st := range stores {
+		st.Close()
+		st.Close()
+		if !stopped(st) {
+			t.Error("cleaner must be stopped by close")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st := NewContext(ctx, StoreNoExpiration, time.Millisecond)
+	cancel()
+	if !stopped(st) {
+		t.Error("cleaner must be stopped by context")
+	}
+	st.Close()
+
+	st = New(StoreNoExpiration, 0)
+	st.Close()
+	st.SetLock("a", true, StoreNoExpiration)
+	if _, ok := st.GetLock("a", false); !ok {
+		t.Error("store must stay usable after close")
+	}
+}
+
+func TestStoreEvictionCallbacks(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, time.Millisecond)
+	defer st.Close()
+
+	evicted := make(chan string, 10)
+	st.OnEvicted(func(key string, value interface{}, reason EvictionReason) {
+		if key == "last" {
+			return
+		}
+		// store must be unlocked here
+		st.SetLock("last", key, StoreNoExpiration)
+		evicted <- key + ":" + value.(string) + ":" + reason.String()
+	})
+	expired := make(chan string, 10)
+	st.OnExpired(func(key string, value interface{}) {
+		expired <- key
+	})
+
+	expect := func(ch chan string, v string) {
+		select {
+		case x := <-ch:
+			if x != v {
+				t.Error("expected", v, "got", x)
+			}
+		case <-time.After(time.Second):
+			t.Error("callback is not called, expected", v)
+		}
+	}
+
+	st.SetLock("a", "1", StoreNoExpiration)
+	st.SetLock("a", "2", StoreNoExpiration)
+	expect(evicted, "a:1:replaced")
+	st.DeleteLock("a")
+	expect(evicted, "a:2:deleted")
+	st.DeleteLock("a")
+
+	st.Lock()
+	st.Set("b", "1", StoreNoExpiration)
+	st.Delete("b")
+	if len(evicted) != 0 {
+		t.Error("callbacks must not be called under lock")
+	}
+	st.Unlock()
+	expect(evicted, "b:1:deleted")
+
+	st.SetLock("c", "1", 5*time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	expect(evicted, "c:1:expired")
+	expect(expired, "c")
+	if x, _ := st.GetLock("last", false); x != "c" {
+		t.Error("callback must be able to use the store")
+	}
+	if len(expired) != 0 {
+		t.Error("OnExpired must be called only for expired items")
+	}
+}
+
+func TestStoreCapacity(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+	st.SetCapacity(2, 0, NewLRU[string]())
+
+	var evicted []string
+	st.OnEvicted(func(key string, value interface{}, reason EvictionReason) {
+		if reason == EvictionCapacity {
+			evicted = append(evicted, key)
+		}
+	})
+
+	st.SetLock("a", 1, StoreNoExpiration)
+	st.SetLock("b", 2, StoreNoExpiration)
+	st.GetLock("a", false)
+	st.SetLock("c", 3, StoreNoExpiration)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("LRU must evict least recently used item, evicted:", evicted)
+	}
+	if len(st.GetAllLock()) != 2 {
+		t.Error("must be two elements")
+	}
+	st.SetLock("a", 4, StoreNoExpiration)
+	if len(evicted) != 1 {
+		t.Error("replace must not evict")
+	}
+
+	evicted = nil
+	st = NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+	st.OnEvicted(func(key string, value interface{}, reason EvictionReason) {
+		if reason == EvictionCapacity {
+			evicted = append(evicted, key)
+		}
+	})
+	st.SetCapacity(2, 0, NewLFU[string]())
+	st.SetLock("a", 1, StoreNoExpiration)
+	st.SetLock("b", 2, StoreNoExpiration)
+	st.GetLock("a", false)
+	st.GetLock("a", false)
+	st.GetLock("b", false)
+	st.SetLock("c", 3, StoreNoExpiration)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("LFU must evict least frequently used item, evicted:", evicted)
+	}
+	st.SetLock("d", 4, StoreNoExpiration)
+	if len(evicted) != 2 || evicted[1] != "c" {
+		t.Error("LFU must evict least frequently used item, evicted:", evicted)
+	}
+
+	evicted = nil
+	st = NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+	st.OnEvicted(func(key string, value interface{}, reason EvictionReason) {
+		if reason == EvictionCapacity {
+			evicted = append(evicted, key)
+		}
+	})
+	st.SetCostFunc(func(key string, value interface{}) int64 {
+		return int64(len(value.(string)))
+	})
+	st.SetCapacity(0, 10, nil)
+	st.SetLock("a", "aaaa", StoreNoExpiration)
+	st.SetLock("b", "bbbb", StoreNoExpiration)
+	st.SetLock("a", "aaaaa", StoreNoExpiration)
+	if len(evicted) != 0 {
+		t.Error("must fit into max cost, evicted:", evicted)
+	}
+	st.SetLock("c", "cc", StoreNoExpiration)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("must evict by cost, evicted:", evicted)
+	}
+	st.SetLock("d", "ddddddddddd", StoreNoExpiration)
+	if len(evicted) != 2 || evicted[1] != "d" {
+		t.Error("only oversized item must be evicted, evicted:", evicted)
+	}
+	if len(st.GetAllLock()) != 2 || st.cost != 7 {
+		t.Error("oversized item must not empty the store", len(st.GetAllLock()), st.cost)
+	}
+	st.SetLock("c", "ccccccccccc", StoreNoExpiration)
+	if _, ok := st.GetLock("c", false); ok || len(st.GetAllLock()) != 1 || st.cost != 5 {
+		t.Error("oversized replacement must drop old value", len(st.GetAllLock()), st.cost)
+	}
+
+	evicted = nil
+	st = NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+	st.OnEvicted(func(key string, value interface{}, reason EvictionReason) {
+		if reason == EvictionCapacity {
+			evicted = append(evicted, key)
+		}
+	})
+	st.SetLock("a", 1, 50*time.Millisecond)
+	st.SetLock("b", 2, 50*time.Millisecond)
+	st.SetCapacity(2, 0, nil)
+	st.RefreshExpirationLock("a")
+	st.SetLock("c", 3, StoreNoExpiration)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("refresh of expiration must count as access, evicted:", evicted)
+	}
+}
+
+func TestStoreSnapshot(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+	st.SetLock("a", 1, StoreNoExpiration)
+	st.SetLock("b", "str", time.Hour)
+	st.SetLock("c", true, 5*time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	err := st.SaveTo(buf)
+	if err != nil {
+		t.Error("fail to save", err)
+	}
+	clock.Advance(10 * time.Millisecond)
+
+	st = NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+	err = st.LoadFrom(buf)
+	if err != nil {
+		t.Error("fail to load", err)
+	}
+	if x, _ := st.GetLock("a", false); x != 1 {
+		t.Error("bad value", x)
+	}
+	if x, _ := st.GetLock("b", false); x != "str" {
+		t.Error("bad value", x)
+	}
+	if ttl, _ := st.TTLLock("b"); ttl != time.Hour-10*time.Millisecond {
+		t.Error("expiration must be kept")
+	}
+	if _, ok := st.store["c"]; ok {
+		t.Error("expired item must be skipped on load")
+	}
+
+	ts := NewTypedWithClock[int, []string](context.Background(), clock, StoreNoExpiration, 0)
+	ts.SetCodec(JSONCodec)
+	ts.SetLock(1, []string{"x", "y"}, StoreNoExpiration)
+	path := filepath.Join(t.TempDir(), "store.json")
+	err = ts.SaveToFile(path)
+	if err != nil {
+		t.Error("fail to save", err)
+	}
+
+	ts = NewTypedWithClock[int, []string](context.Background(), clock, StoreNoExpiration, 0)
+	ts.SetCodec(JSONCodec)
+	err = ts.LoadFromFile(path)
+	if err != nil {
+		t.Error("fail to load", err)
+	}
+	if x, _ := ts.GetLock(1, false); len(x) != 2 || x[1] != "y" {
+		t.Error("bad value", x)
+	}
+	err = ts.LoadFromFile(path + ".not_exists")
+	if err != nil {
+		t.Error("not existing file must be ignored", err)
+	}
+}
+
+func TestShardedStore(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewShardedWithClock[string, int](context.Background(), clock, 8, StoreNoExpiration, time.Millisecond)
+	defer st.Close()
+
+	for i := 0; i < 100; i++ {
+		st.SetLock(strconv.Itoa(i), i, StoreNoExpiration)
+	}
+	for i := 0; i < 100; i++ {
+		x, ok := st.GetLock(strconv.Itoa(i), false)
+		if !ok || x != i {
+			t.Error("bad value", i, x)
+		}
+	}
+	if len(st.GetAllLock()) != 100 {
+		t.Error("must be 100 elements")
+	}
+	st.DeleteLock("1")
+	if _, ok := st.GetLock("1", false); ok {
+		t.Error("got value while key is not exists")
+	}
+
+	expired := make(chan string, 1)
+	st.OnExpired(func(key string, value int) {
+		expired <- key
+	})
+	st.SetLock("a", 1, 5*time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	select {
+	case key := <-expired:
+		if key != "a" {
+			t.Error("bad expired key", key)
+		}
+	case <-time.After(time.Second):
+		t.Error("expired callback is not called")
+	}
+}
+
+func TestShardedStoreCapacity(t *testing.T) {
+	st := NewSharded[string, int](8, StoreNoExpiration, 0)
+	defer st.Close()
+
+	if err := st.SetCapacity(4, 0, nil); err != ErrCapacityTooSmall {
+		t.Error("limit smaller than count of shards must be rejected", err)
+	}
+
+	if err := st.SetCapacity(10, 0, nil); err != nil {
+		t.Error("fail to set capacity", err)
+	}
+	for i := 0; i < 100; i++ {
+		st.SetLock(strconv.Itoa(i), i, StoreNoExpiration)
+	}
+	if n := len(st.GetAllLock()); n > 10 || n < 8 {
+		t.Error("total count must not exceed the limit", n)
+	}
+}
+
+func benchmarkMixed(b *testing.B, set func(key string, value int), get func(key string) (int, bool)) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		set(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				set(key, i)
+			} else {
+				get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkStoreMixed(b *testing.B) {
+	st := NewTyped[string, int](StoreNoExpiration, 0)
+	benchmarkMixed(b,
+		func(key string, value int) { st.SetLock(key, value, StoreNoExpiration) },
+		func(key string) (int, bool) { return st.GetLock(key, false) },
+	)
+}
+
+func BenchmarkShardedStoreMixed(b *testing.B) {
+	st := NewSharded[string, int](0, StoreNoExpiration, 0)
+	benchmarkMixed(b,
+		func(key string, value int) { st.SetLock(key, value, StoreNoExpiration) },
+		func(key string) (int, bool) { return st.GetLock(key, false) },
+	)
+}
+
+func TestStoreAtomic(t *testing.T) {
+	st := New(StoreNoExpiration, 0)
+
+	x, loaded := st.GetOrSetLock("a", 1, StoreNoExpiration)
+	if loaded || x != 1 {
+		t.Error("must be set", x)
+	}
+	x, loaded = st.GetOrSetLock("a", 2, StoreNoExpiration)
+	if !loaded || x != 1 {
+		t.Error("must be loaded", x)
+	}
+
+	x, err := st.IncrementLock("cnt", 5, time.Hour)
+	if err != nil || x != 5 {
+		t.Error("bad increment", x, err)
+	}
+	expiration := st.store["cnt"].expiration
+	x, err = st.IncrementLock("cnt", 2, StoreNoExpiration)
+	if err != nil || x != 7 {
+		t.Error("bad increment", x, err)
+	}
+	x, err = st.DecrementLock("cnt", 10, StoreNoExpiration)
+	if err != nil || x != -3 {
+		t.Error("bad decrement", x, err)
+	}
+	if st.store["cnt"].expiration != expiration {
+		t.Error("increment must keep expiration")
+	}
+	_, err = st.IncrementLock("cnt", 1.5, StoreNoExpiration)
+	if !errors.Is(err, ErrNotNumeric) {
+		t.Error("must fail on different types", err)
+	}
+	st.SetLock("s", "str", StoreNoExpiration)
+	_, err = st.IncrementLock("s", 1, StoreNoExpiration)
+	if !errors.Is(err, ErrNotNumeric) {
+		t.Error("must fail on not numeric value", err)
+	}
+
+	type count int
+	cs := NewTyped[string, count](StoreNoExpiration, 0)
+	cs.IncrementLock("c", 3, StoreNoExpiration)
+	c, err := cs.DecrementLock("c", 1, StoreNoExpiration)
+	if err != nil || c != 2 {
+		t.Error("bad increment of named numeric type", c, err)
+	}
+	ds := NewTyped[string, time.Duration](StoreNoExpiration, 0)
+	d, err := ds.IncrementLock("d", time.Second, StoreNoExpiration)
+	if err != nil || d != time.Second {
+		t.Error("bad increment of duration", d, err)
+	}
+	us := NewTyped[string, uint8](StoreNoExpiration, 0)
+	u, err := us.DecrementLock("u", 1, StoreNoExpiration)
+	if err != nil || u != 255 {
+		t.Error("unsigned decrement must wrap like in go", u, err)
+	}
+
+	ts := NewTyped[string, float64](StoreNoExpiration, 0)
+	f, err := ts.DecrementLock("f", 0.5, StoreNoExpiration)
+	if err != nil || f != -0.5 {
+		t.Error("bad decrement", f, err)
+	}
+
+	if st.CompareAndSwapLock("a", 2, 3) {
+		t.Error("must not swap different value")
+	}
+	if !st.CompareAndSwapLock("a", 1, 3) {
+		t.Error("must swap equal value")
+	}
+	if x, _ = st.GetLock("a", false); x != 3 {
+		t.Error("bad value", x)
+	}
+	st.SetLock("sl", []int{1}, StoreNoExpiration)
+	if !st.CompareAndSwapLock("sl", []int{1}, []int{2}) {
+		t.Error("must swap equal not comparable value")
+	}
+
+	x, keep := st.UpdateLock("a", func(value interface{}, found bool) (interface{}, bool) {
+		return value.(int) * 10, found
+	})
+	if !keep || x != 30 {
+		t.Error("bad update", x)
+	}
+	st.UpdateLock("a", func(value interface{}, found bool) (interface{}, bool) {
+		return nil, false
+	})
+	if _, ok := st.GetLock("a", false); ok {
+		t.Error("must be deleted by update")
+	}
+}
+
+func TestStoreGetOrCompute(t *testing.T) {
+	st := NewTyped[string, int](StoreNoExpiration, 0)
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func() (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			x, err := st.GetOrCompute("a", StoreNoExpiration, loader)
+			if err != nil || x != 42 {
+				t.Error("bad value", x, err)
+			}
+		}()
+	}
+	<-time.After(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Error("loader must be called once, called:", calls)
+	}
+
+	_, err := st.GetOrCompute("b", StoreNoExpiration, func() (int, error) {
+		return 0, errors.New("fail")
+	})
+	if err == nil {
+		t.Error("error must be returned")
+	}
+	if _, ok := st.GetLock("b", false); ok {
+		t.Error("error must not be cached")
+	}
+}
+
+func TestStoreBulk(t *testing.T) {
+	st := New(StoreNoExpiration, 0)
+
+	st.SetManyLock(map[string]interface{}{
+		"sess:1:a": 1,
+		"sess:1:b": 2,
+		"sess:2:a": 3,
+		"user:1":   4,
+	}, StoreNoExpiration)
+
+	if keys := st.KeysLock("sess:1:"); len(keys) != 2 {
+		t.Error("must be two keys", keys)
+	}
+	if keys := st.KeysLock(""); len(keys) != 4 {
+		t.Error("must be four keys", keys)
+	}
+	rows := st.GetByPrefixLock("sess:2:")
+	if len(rows) != 1 || rows[0].Key != "sess:2:a" || rows[0].Value != 3 {
+		t.Error("bad rows", rows)
+	}
+
+	cnt := 0
+	st.RangeLock(func(key string, value interface{}) bool {
+		cnt++
+		return cnt < 2
+	})
+	if cnt != 2 {
+		t.Error("range must stop when fn returns false")
+	}
+
+	got := st.GetManyLock([]string{"sess:1:a", "user:1", "not_exists"})
+	if len(got) != 2 || got["user:1"] != 4 {
+		t.Error("bad get many", got)
+	}
+
+	if n := st.DeleteByPrefixLock("sess:"); n != 3 {
+		t.Error("must delete three items, deleted:", n)
+	}
+	st.DeleteManyLock([]string{"user:1"})
+	if len(st.GetAllLock()) != 0 {
+		t.Error("store must be empty")
+	}
+
+	ts := NewTyped[int, string](StoreNoExpiration, 0)
+	ts.SetManyLock(map[int]string{10: "a", 11: "b", 2: "c"}, StoreNoExpiration)
+	if keys := ts.KeysLock("1"); len(keys) != 2 {
+		t.Error("not string keys must be matched by fmt representation", keys)
+	}
+
+	sh := NewSharded[string, int](4, StoreNoExpiration, 0)
+	sh.SetManyLock(map[string]int{"a:1": 1, "a:2": 2, "b:1": 3}, StoreNoExpiration)
+	if keys := sh.KeysLock("a:"); len(keys) != 2 {
+		t.Error("must be two keys", keys)
+	}
+	if got := sh.GetManyLock([]string{"a:1", "b:1"}); len(got) != 2 {
+		t.Error("bad get many", got)
+	}
+	if n := sh.DeleteByPrefixLock("a:"); n != 2 {
+		t.Error("must delete two items, deleted:", n)
+	}
+	sh.DeleteManyLock([]string{"b:1"})
+	if len(sh.GetAllLock()) != 0 {
+		t.Error("store must be empty")
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+	st.SetCapacity(2, 0, nil)
+
+	st.SetLock("a", "aaaa", StoreNoExpiration)
+	st.SetLock("b", 1, 5*time.Millisecond)
+	st.GetLock("a", false)
+	st.GetLock("x", false)
+	st.SetLock("c", []int{1, 2}, StoreNoExpiration)
+	st.DeleteLock("c")
+	st.SetLock("d", nil, 5*time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+	st.GetLock("d", false)
+
+	stats := st.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Error("bad lookup stats", stats)
+	}
+	if stats.Sets != 4 || stats.Deletes != 1 || stats.Evictions != 1 || stats.Expirations != 1 {
+		t.Error("bad stats", stats)
+	}
+	if stats.Items != 1 || stats.ApproxBytes <= 0 {
+		t.Error("bad size stats", stats)
+	}
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(map[string]StatsSource{"main": st}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "# TYPE lily_store_hits_total counter") ||
+		!strings.Contains(body, `lily_store_hits_total{store="main"} 1`) ||
+		!strings.Contains(body, `lily_store_items{store="main"} 1`) {
+		t.Error("bad metrics", body)
+	}
+}
+
+func TestStoreTags(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+
+	st.SetWithTagsLock("user:42", 1, StoreNoExpiration, "user:42")
+	st.SetWithTagsLock("view:42:profile", 2, StoreNoExpiration, "user:42", "views")
+	st.SetWithTagsLock("view:43:profile", 3, StoreNoExpiration, "user:43", "views")
+	st.SetLock("other", 4, StoreNoExpiration)
+
+	if n := st.InvalidateTagLock("user:42"); n != 2 {
+		t.Error("must invalidate two items, invalidated:", n)
+	}
+	if len(st.GetAllLock()) != 2 {
+		t.Error("must be two elements")
+	}
+	if _, ok := st.tagIndex["user:42"]; ok {
+		t.Error("tag index must be cleaned")
+	}
+	if keys := st.tagIndex["views"]; len(keys) != 1 {
+		t.Error("tag index must be kept consistent on delete", keys)
+	}
+
+	st.SetLock("view:43:profile", 5, StoreNoExpiration)
+	if len(st.tagIndex) != 0 {
+		t.Error("replaced item must lose its tags", st.tagIndex)
+	}
+	if n := st.InvalidateTagLock("views"); n != 0 {
+		t.Error("nothing to invalidate, invalidated:", n)
+	}
+
+	st = NewWithClock(context.Background(), clock, StoreNoExpiration, time.Millisecond)
+	defer st.Close()
+	expired := make(chan struct{})
+	st.OnExpired(func(key string, value interface{}) {
+		close(expired)
+	})
+	st.SetWithTagsLock("a", 1, 5*time.Millisecond, "t")
+	clock.Advance(5 * time.Millisecond)
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Error("cleaner is not called")
+	}
+	st.Lock()
+	if len(st.tagIndex) != 0 {
+		t.Error("cleaner must clean tag index", st.tagIndex)
+	}
+	st.Unlock()
+}
+
+func TestStoreTTL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+
+	if _, ok := st.TTLLock("a"); ok {
+		t.Error("got ttl of not existing key")
+	}
+
+	st.SetLock("a", 1, time.Hour)
+	clock.Advance(time.Minute)
+	ttl, ok := st.TTLLock("a")
+	if !ok || ttl != 59*time.Minute {
+		t.Error("bad ttl", ttl)
+	}
+	st.SetLock("b", 1, StoreNoExpiration)
+	ttl, ok = st.TTLLock("b")
+	if !ok || ttl != StoreNoExpiration {
+		t.Error("bad ttl of item without expiration", ttl)
+	}
+	at, ok := st.ExpiresAtLock("b")
+	if !ok || !at.IsZero() {
+		t.Error("bad expiration time of item without expiration", at)
+	}
+
+	deadline := clock.Now().Add(30 * time.Minute)
+	st.SetWithDeadlineLock("c", 1, deadline)
+	at, ok = st.ExpiresAtLock("c")
+	if !ok || !at.Equal(time.Unix(0, deadline.UnixNano())) {
+		t.Error("bad expiration time", at)
+	}
+	st.RefreshExpirationLock("c")
+	st.GetLock("c", true)
+	if at, _ = st.ExpiresAtLock("c"); !at.Equal(time.Unix(0, deadline.UnixNano())) {
+		t.Error("deadline must not be moved by refresh", at)
+	}
+	st.SetWithDeadlineLock("c", 1, clock.Now().Add(-time.Second))
+	if _, ok = st.GetLock("c", false); ok {
+		t.Error("deadline in the past must delete the key")
+	}
+
+	if !st.PersistLock("a") {
+		t.Error("must persist existing key")
+	}
+	if ttl, _ = st.TTLLock("a"); ttl != StoreNoExpiration {
+		t.Error("persisted key must have no expiration", ttl)
+	}
+	if st.PersistLock("not_exists") {
+		t.Error("must not persist not existing key")
+	}
+
+	if !st.ExpireLock("a", 5*time.Millisecond) {
+		t.Error("must expire existing key")
+	}
+	clock.Advance(10 * time.Millisecond)
+	if _, ok = st.GetLock("a", false); ok {
+		t.Error("got expired value")
+	}
+	if !st.ExpireLock("b", 0) {
+		t.Error("must expire existing key")
+	}
+	if _, ok = st.GetLock("b", false); ok {
+		t.Error("not positive duration must delete the key")
+	}
+}
+
+func TestStoreSubscribe(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	st := NewWithClock(context.Background(), clock, StoreNoExpiration, 0)
+
+	sub := st.Subscribe("flag:", 10, DropNewest)
+	st.SetLock("flag:a", 1, StoreNoExpiration)
+	st.SetLock("other", 1, StoreNoExpiration)
+	st.SetLock("flag:a", 2, StoreNoExpiration)
+	st.DeleteLock("flag:a")
+	st.SetLock("flag:b", 3, 5*time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+	st.GetLock("flag:b", true)
+
+	expected := []string{"set:flag:a:1", "set:flag:a:2", "delete:flag:a:2", "set:flag:b:3", "expire:flag:b:3"}
+	for _, exp := range expected {
+		select {
+		case e := <-sub.C:
+			if got := e.Type.String() + ":" + e.Key + ":" + strconv.Itoa(e.Value.(int)); got != exp {
+				t.Error("expected", exp, "got", got)
+			}
+		default:
+			t.Error("event is not received", exp)
+		}
+	}
+	if len(sub.C) != 0 {
+		t.Error("unexpected events", len(sub.C))
+	}
+
+	sub.Close()
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("channel must be closed")
+	}
+	st.SetLock("flag:a", 1, StoreNoExpiration)
+
+	newest := st.Subscribe("", 2, DropNewest)
+	oldest := st.Subscribe("", 2, DropOldest)
+	for i := 0; i < 5; i++ {
+		st.SetLock("k", i, StoreNoExpiration)
+	}
+	if newest.Dropped() != 3 || oldest.Dropped() != 3 {
+		t.Error("must drop three events", newest.Dropped(), oldest.Dropped())
+	}
+	if e := <-newest.C; e.Value != 0 {
+		t.Error("DropNewest must keep oldest events", e.Value)
+	}
+	if e := <-oldest.C; e.Value != 3 {
+		t.Error("DropOldest must keep newest events", e.Value)
+	}
+	newest.Close()
+	oldest.Close()
+
+	sh := NewShardedWithClock[string, int](context.Background(), clock, 4, StoreNoExpiration, 0)
+	received := make(chan string, 10)
+	shSub := sh.SubscribeFunc("", 10, DropNewest, func(e Event[string, int]) {
+		received <- e.Key
+	})
+	defer shSub.Close()
+	sh.SetLock("a", 1, StoreNoExpiration)
+	sh.SetLock("b", 1, StoreNoExpiration)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Error("callback is not called")
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"time"
+)
+
+// alive returns not expired item without any side effects
+func (s *TypedStore[K, V]) alive(key K, now int64) *TypedItem[V] {
+	item, found := s.store[key]
+	if !found || item.expired(now) {
+		return nil
+	}
+	return item
+}
+
+// TTL returns remaining time to live, StoreNoExpiration for item without expiration
+func (s *TypedStore[K, V]) TTL(key K) (time.Duration, bool) {
+	now := s.clock.Now().UnixNano()
+	item := s.alive(key, now)
+	if item == nil {
+		return 0, false
+	}
+	if item.expiration == 0 {
+		return StoreNoExpiration, true
+	}
+	return time.Duration(item.expiration - now), true
+}
+
+func (s *TypedStore[K, V]) TTLLock(key K) (time.Duration, bool) {
+	s.mu.RLock()
+	ttl, found := s.TTL(key)
+	s.mu.RUnlock()
+	return ttl, found
+}
+
+// ExpiresAt returns zero time for item without expiration
+func (s *TypedStore[K, V]) ExpiresAt(key K) (time.Time, bool) {
+	item := s.alive(key, s.clock.Now().UnixNano())
+	if item == nil {
+		return time.Time{}, false
+	}
+	if item.expiration == 0 {
+		return time.Time{}, true
+	}
+	return time.Unix(0, item.expiration), true
+}
+
+func (s *TypedStore[K, V]) ExpiresAtLock(key K) (time.Time, bool) {
+	s.mu.RLock()
+	t, found := s.ExpiresAt(key)
+	s.mu.RUnlock()
+	return t, found
+}
+
+// SetWithDeadline sets item expiring at absolute time, RefreshExpiration does not move the deadline.
+// Deadline in the past deletes the key.
+func (s *TypedStore[K, V]) SetWithDeadline(key K, value V, deadline time.Time) {
+	now := s.clock.Now()
+	if !deadline.After(now) {
+		s.Delete(key)
+		return
+	}
+	s.set(key, value, StoreNoExpiration, deadline.UnixNano(), nil, now.UnixNano())
+}
+
+func (s *TypedStore[K, V]) SetWithDeadlineLock(key K, value V, deadline time.Time) {
+	s.Lock()
+	s.SetWithDeadline(key, value, deadline)
+	s.Unlock()
+}
+
+// Persist removes expiration of the item, returns false if item is not found
+func (s *TypedStore[K, V]) Persist(key K) bool {
+	item := s.alive(key, s.clock.Now().UnixNano())
+	if item == nil {
+		return false
+	}
+	item.duration = StoreNoExpiration
+	item.expiration = 0
+	return true
+}
+
+func (s *TypedStore[K, V]) PersistLock(key K) bool {
+	s.Lock()
+	ok := s.Persist(key)
+	s.Unlock()
+	return ok
+}
+
+// Expire sets new duration of the item counting from now, not positive duration deletes the item.
+// Returns false if item is not found.
+func (s *TypedStore[K, V]) Expire(key K, duration time.Duration) bool {
+	now := s.clock.Now()
+	item := s.alive(key, now.UnixNano())
+	if item == nil {
+		return false
+	}
+	if duration <= 0 {
+		s.Delete(key)
+		return true
+	}
+	item.duration = duration
+	item.expiration = now.Add(duration).UnixNano()
+	return true
+}
+
+func (s *TypedStore[K, V]) ExpireLock(key K, duration time.Duration) bool {
+	s.Lock()
+	ok := s.Expire(key, duration)
+	s.Unlock()
+	return ok
+}
+
+func (s *ShardedStore[K, V]) TTLLock(key K) (time.Duration, bool) {
+	return s.shard(key).TTLLock(key)
+}
+
+func (s *ShardedStore[K, V]) ExpiresAtLock(key K) (time.Time, bool) {
+	return s.shard(key).ExpiresAtLock(key)
+}
+
+func (s *ShardedStore[K, V]) SetWithDeadlineLock(key K, value V, deadline time.Time) {
+	s.shard(key).SetWithDeadlineLock(key, value, deadline)
+}
+
+func (s *ShardedStore[K, V]) PersistLock(key K) bool {
+	return s.shard(key).PersistLock(key)
+}
+
+func (s *ShardedStore[K, V]) ExpireLock(key K, duration time.Duration) bool {
+	return s.shard(key).ExpireLock(key, duration)
+}
@@ -0,0 +1,394 @@
+package lily
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "panics.jsonl")
+
+	r, err := NewFileReporter(path)
+	if err != nil {
+		t.Error("fail to create reporter", err)
+		return
+	}
+	SetReporter(r)
+	defer SetReporter(nil)
+
+	for i := 0; i < 2; i++ {
+		func() {
+			defer func() {
+				if v, ok := recover().(string); !ok || !strings.HasPrefix(v, "boom") {
+					t.Error("ErrPanicWS must panic with string", v)
+				}
+			}()
+			ErrPanicWS(errors.New("boom"))
+		}()
+	}
+	ReportPanic("handler", []byte("stack"), &ReportRequest{Method: "GET", URL: "/x"})
+
+	if err = r.Close(); err != nil {
+		t.Error("fail to close reporter", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Error("fail to open reports", err)
+		return
+	}
+	defer f.Close()
+
+	var reports []*PanicReport
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		report := &PanicReport{}
+		if err = json.Unmarshal(sc.Bytes(), report); err != nil {
+			t.Error("fail to parse report", err)
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) != 3 {
+		t.Error("must be three reports", len(reports))
+		return
+	}
+	if reports[0].Error != "boom" || reports[0].Stack == "" || reports[0].Request != nil {
+		t.Error("bad report", reports[0])
+	}
+	if reports[2].Request == nil || reports[2].Request.URL != "/x" {
+		t.Error("bad report", reports[2])
+	}
+}
+
+func TestSentryReporter(t *testing.T) {
+	var (
+		path, auth string
+		lines      [][]byte
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("X-Sentry-Auth")
+		body, _ := io.ReadAll(r.Body)
+		lines = bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	}))
+	defer srv.Close()
+
+	dsn := strings.Replace(srv.URL, "://", "://public@", 1) + "/42"
+
+	r, err := NewSentryReporter(dsn, srv.Client())
+	if err != nil {
+		t.Error("fail to create reporter", err)
+		return
+	}
+
+	ReportPanic("no reporter", nil, nil)
+	if lines != nil {
+		t.Error("reported without reporter")
+	}
+
+	SetReporter(r)
+	defer SetReporter(nil)
+
+	ReportPanic("boom", []byte("stack"), &ReportRequest{Method: "POST", URL: "/items"})
+
+	if path != "/api/42/envelope/" {
+		t.Error("bad path", path)
+	}
+	if !strings.Contains(auth, "sentry_key=public") {
+		t.Error("bad auth header", auth)
+	}
+	if len(lines) != 3 {
+		t.Error("envelope must have three lines", len(lines))
+		return
+	}
+
+	item := struct {
+		Type   string `json:"type"`
+		Length int    `json:"length"`
+	}{}
+	if err = json.Unmarshal(lines[1], &item); err != nil {
+		t.Error("fail to parse item header", err)
+	}
+	if item.Type != "event" || item.Length != len(lines[2]) {
+		t.Error("bad item header", string(lines[1]))
+	}
+
+	event := struct {
+		Exception struct {
+			Values []struct {
+				Value string `json:"value"`
+			} `json:"values"`
+		} `json:"exception"`
+		Request struct {
+			Method string `json:"method"`
+		} `json:"request"`
+	}{}
+	if err = json.Unmarshal(lines[2], &event); err != nil {
+		t.Error("fail to parse event", err)
+	}
+	if len(event.Exception.Values) != 1 || event.Exception.Values[0].Value != "boom" || event.Request.Method != "POST" {
+		t.Error("bad event", string(lines[2]))
+	}
+
+	if _, err = NewSentryReporter("https://sentry.io/42", nil); err == nil {
+		t.Error("dsn without key accepted")
+	}
+}
+
+func TestVErr(t *testing.T) {
+	notFound := &VErr{Code: "not_found", Detail: "Not found"}
+
+	err := fmt.Errorf("get item: %w", NewVErr(nil, "not_found", "Item not found", nil))
+	if !errors.Is(err, notFound) {
+		t.Error("wrapped VErr must match VErr with the same code")
+	}
+	if errors.Is(err, &VErr{Code: "bad_json"}) {
+		t.Error("wrapped VErr must not match VErr with other code")
+	}
+	if errors.Is(err, (*VErr)(nil)) {
+		t.Error("VErr must not match nil VErr")
+	}
+	var vErr *VErr
+	if !errors.As(err, &vErr) || vErr.Detail != "Item not found" {
+		t.Error("fail to get VErr from the chain", err)
+	}
+
+	cause := errors.New("cause")
+	if !errors.Is(NewVErr(cause, "internal_error", "", nil), cause) {
+		t.Error("VErr must unwrap to Err")
+	}
+
+	if s := notFound.StatusCode(); s != http.StatusNotFound {
+		t.Error("bad status of registered code", s)
+	}
+	if s := (&VErr{Code: "not_found", Status: http.StatusGone}).StatusCode(); s != http.StatusGone {
+		t.Error("explicit status must take precedence over registry", s)
+	}
+	if s := (&VErr{Code: "test_unknown"}).StatusCode(); s != http.StatusBadRequest {
+		t.Error("unknown code must have 400 status", s)
+	}
+
+	RegisterVErrCode("test_conflict", http.StatusConflict)
+	if s := VErrCodeStatus("test_conflict"); s != http.StatusConflict {
+		t.Error("registered code has bad status", s)
+	}
+	if s := (&VErr{Code: "test_conflict"}).StatusCode(); s != http.StatusConflict {
+		t.Error("VErr with registered code has bad status", s)
+	}
+}
+
+func TestVErrLocalize(t *testing.T) {
+	hostile := `{{printf "%s%s" "INJ" "ECTED"}}`
+
+	// fills pars like go-i18n does
+	translate := func(id string, args ...interface{}) string {
+		tr := map[string]string{"Hello {{.Name}}": "Привет {{.Name}}"}[id]
+		if tr == "" {
+			return id
+		}
+		if len(args) > 0 {
+			for k, v := range args[0].(map[string]interface{}) {
+				tr = strings.ReplaceAll(tr, "{{."+k+"}}", v.(string))
+			}
+		}
+		return tr
+	}
+
+	e := NewVErr(nil, "bad", "Hello {{.Name}}", map[string]string{"Name": "Bob"})
+	if d := e.Localize(translate); d != "Привет Bob" {
+		t.Error("bad translated detail", d)
+	}
+	if d := e.Localize(nil); d != "Hello Bob" {
+		t.Error("bad untranslated detail", d)
+	}
+
+	e = NewVErr(nil, "bad", "Bye {{.Name}}", map[string]string{"Name": "Bob"})
+	if d := e.Localize(translate); d != "Bye Bob" {
+		t.Error("bad detail without translation", d)
+	}
+
+	e = NewVErr(nil, "bad", "Hello {{.Name}}", map[string]string{"Name": hostile})
+	if d := e.Localize(translate); d != "Привет "+hostile {
+		t.Error("pars of translated detail must not be executed", d)
+	}
+	if d := e.Localize(nil); d != "Hello "+hostile {
+		t.Error("pars of untranslated detail must not be executed", d)
+	}
+}
+
+func TestErrWrap(t *testing.T) {
+	if ErrWrap(nil) != nil {
+		t.Error("nil must stay nil")
+	}
+
+	cause := errors.New("cause")
+	err := ErrWrap(cause, "load")
+	var cErr *CallerErr
+	if !errors.As(err, &cErr) {
+		t.Error("must be CallerErr", err)
+	} else {
+		if !strings.HasSuffix(cErr.File, "test.go") || cErr.Line == 0 || cErr.Msg != "load" {
+			t.Error("bad caller location", cErr.File, cErr.Line, cErr.Msg)
+		}
+		if !strings.HasSuffix(err.Error(), ": load: cause") {
+			t.Error("bad error text", err)
+		}
+	}
+	if !errors.Is(err, cause) || errors.Unwrap(err) != cause {
+		t.Error("CallerErr must unwrap to the cause")
+	}
+
+	wrapInLib := func() error {
+		return ErrWrapCaller(cause)
+	}
+	err = wrapInLib()
+	if !errors.As(err, &cErr) || !strings.HasSuffix(cErr.File, "test.go") || !errors.Is(err, cause) {
+		t.Error("ErrWrapCaller must take location of the caller of the function", err)
+	}
+	if ErrWrapCaller(nil) != nil {
+		t.Error("nil must stay nil")
+	}
+
+	if v := Must(7, nil); v != 7 {
+		t.Error("Must must return value", v)
+	}
+	func() {
+		defer func() {
+			rErr, ok := recover().(error)
+			if !ok || !errors.Is(rErr, cause) || !errors.As(rErr, &cErr) || !strings.HasSuffix(cErr.File, "test.go") {
+				t.Error("Must must panic with wrapped error", rErr)
+			}
+		}()
+		Must(0, cause)
+	}()
+}
+
+func TestVErrs(t *testing.T) {
+	errs := VErrs{}
+	if !errs.Empty() || errs.Err() != nil {
+		t.Error("empty VErrs must give nil error")
+	}
+
+	errs.Add("name", "required", "Name is required", nil)
+	errs.AddVErr("ignored", nil)
+
+	nested := VErrs{}
+	nested.Add("title", "too_long", "Title is too long", nil)
+	errs.Merge("items.0", nested)
+	errs.Merge("", VErrs{"age": NewVErr(nil, "bad_age", "Bad age", nil)})
+
+	if errs.Empty() || errs.Err() == nil {
+		t.Error("not empty VErrs must give error")
+	}
+	if !errs.Has("name") || !errs.Has("items.0.title") || !errs.Has("age") || errs.Has("ignored") || errs.Has("title") {
+		t.Error("bad fields", errs.Fields())
+	}
+	if s := errs.Error(); s != "age: bad_age, Bad age; items.0.title: too_long, Title is too long; name: required, Name is required" {
+		t.Error("bad error text", s)
+	}
+
+	var vErrs VErrs
+	if !errors.As(fmt.Errorf("save: %w", errs.Err()), &vErrs) || len(vErrs) != 3 {
+		t.Error("fail to get VErrs from the chain")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	if os.Getenv("LILY_TEST_FATAL") != "" {
+		SetLogger(NewJSONLogger(os.Stdout, slog.LevelInfo))
+		ErrFatal(errors.New("fatal cause"), "on start")
+		return
+	}
+
+	if _, ok := Log().(defaultLogger); !ok {
+		t.Error("default logger must be used when not set")
+	}
+
+	buf := &bytes.Buffer{}
+	SetLogger(NewJSONLogger(buf, slog.LevelInfo))
+	defer SetLogger(nil)
+
+	Log().Debug("debug line")
+	Log().Info("info line", "k", 1)
+	if strings.Contains(buf.String(), "debug line") || !strings.Contains(buf.String(), `"msg":"info line","k":1`) {
+		t.Error("bad level filtering", buf.String())
+	}
+
+	buf.Reset()
+	func() {
+		defer func() {
+			if r := recover(); r != "cause load\n" {
+				t.Error("bad ErrPanic panic value", r)
+			}
+		}()
+		ErrPanic(errors.New("cause"), "load")
+	}()
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Error("fail to parse log line", err, buf.String())
+	}
+	if line["level"] != "ERROR" || line["msg"] != "load" || line["error"] != "cause" {
+		t.Error("bad ErrPanic log line", buf.String())
+	}
+
+	SetLogger(nil)
+	if _, ok := Log().(defaultLogger); !ok {
+		t.Error("SetLogger(nil) must restore default logger")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLogger$")
+	cmd.Env = append(os.Environ(), "LILY_TEST_FATAL=1")
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Error("ErrFatal must exit with code 1", err)
+	}
+	line = map[string]interface{}{}
+	if err = json.Unmarshal(bytes.TrimSpace(out), &line); err != nil {
+		t.Error("fail to parse ErrFatal log line", err, string(out))
+	}
+	if line["msg"] != "fatal" || line["details"] != "on start" || line["error"] != "fatal cause" || line["line"] == nil {
+		t.Error("bad ErrFatal log line", string(out))
+	}
+}
+
+func TestSentryReporterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	defer func(d time.Duration) { SentryTimeout = d }(SentryTimeout)
+	SentryTimeout = 50 * time.Millisecond
+
+	r, err := NewSentryReporter(strings.Replace(srv.URL, "://", "://public@", 1)+"/42", nil)
+	if err != nil {
+		t.Error("fail to create reporter", err)
+		return
+	}
+
+	start := time.Now()
+	err = r.Report(&PanicReport{Time: time.Now(), Error: "boom"})
+	if err == nil {
+		t.Error("hung sentry must give error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Error("report must be limited by SentryTimeout", d)
+	}
+}
@@ -0,0 +1,33 @@
+package tmp
+
+import (
+	"errors"
+	"github.com/rendau/lily"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInitErr(t *testing.T) {
+	err := InitErr("", "tmp", time.Hour, time.Hour)
+	var cErr *lily.CallerErr
+	if !errors.As(err, &cErr) || !strings.HasSuffix(cErr.File, "test.go") {
+		t.Error("bad params must return error with the caller location", err)
+	}
+
+	err, _, _, _, _ = UploadFileFromHttpRequestFormErr(nil, "file", "", false, false)
+	if err == nil {
+		t.Error("uninited module must return error")
+	}
+
+	dir := t.TempDir()
+	err = InitErr(dir, "tmp", time.Hour, time.Hour)
+	if err != nil {
+		t.Error("fail to init", err)
+	}
+	if st, err := os.Stat(filepath.Join(dir, "tmp")); err != nil || !st.IsDir() {
+		t.Error("tmp dir must be created", err)
+	}
+}
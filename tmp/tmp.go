@@ -7,7 +7,6 @@ import (
 	"github.com/rendau/lily/zip"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -24,9 +23,20 @@ var (
 	_cleanupInterval time.Duration
 )
 
+var errUninited = errors.New("Tmp module used befor inited")
+
 func Init(dirPath, dirName string, timeLimit time.Duration, cleanupInterval time.Duration) {
+	lily.ErrPanic(initTmp(dirPath, dirName, timeLimit, cleanupInterval))
+}
+
+// InitErr is Init, which returns errors with the caller location instead of panic
+func InitErr(dirPath, dirName string, timeLimit time.Duration, cleanupInterval time.Duration) error {
+	return lily.ErrWrapCaller(initTmp(dirPath, dirName, timeLimit, cleanupInterval))
+}
+
+func initTmp(dirPath, dirName string, timeLimit time.Duration, cleanupInterval time.Duration) error {
 	if dirPath == "" || dirName == "" || timeLimit == 0 || cleanupInterval == 0 {
-		log.Panicln("Bad initial params")
+		return errors.New("Bad initial params")
 	}
 	_dirPath = dirPath
 	_dirName = dirName
@@ -35,17 +45,39 @@ func Init(dirPath, dirName string, timeLimit time.Duration, cleanupInterval time
 	_cleanupInterval = cleanupInterval
 
 	err := os.MkdirAll(_dirFullPath, os.ModePerm)
-	lily.ErrPanic(err)
+	if err != nil {
+		return err
+	}
 
 	go cleaner()
+
+	return nil
 }
 
 func UploadFileFromHttpRequestForm(r *http.Request, key, fnSuffix string,
 	requireExt, extractZip bool) (error, string, string, string, string) {
 	if _dirPath == "" || _dirName == "" {
-		log.Panicln("Tmp module used befor inited")
+		lily.ErrPanic(errUninited)
 	}
 
+	return uploadFileFromHttpRequestForm(r, key, fnSuffix, requireExt, extractZip)
+}
+
+// UploadFileFromHttpRequestFormErr is UploadFileFromHttpRequestForm, which returns error instead of panic,
+// if module is not inited, errors have the caller location
+func UploadFileFromHttpRequestFormErr(r *http.Request, key, fnSuffix string,
+	requireExt, extractZip bool) (error, string, string, string, string) {
+	if _dirPath == "" || _dirName == "" {
+		return lily.ErrWrapCaller(errUninited), "", "", "", ""
+	}
+
+	err, fPath, rPath, eFPath, eRPath := uploadFileFromHttpRequestForm(r, key, fnSuffix, requireExt, extractZip)
+
+	return lily.ErrWrapCaller(err), fPath, rPath, eFPath, eRPath
+}
+
+func uploadFileFromHttpRequestForm(r *http.Request, key, fnSuffix string,
+	requireExt, extractZip bool) (error, string, string, string, string) {
 	fn := generateFilename(fnSuffix)
 
 	err, newFileName := lilyHttp.UploadFileFromRequestForm(